@@ -1,37 +1,93 @@
 package command
 
-import "sync"
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
 
 // Async is the struct returned from async commands.
 type Async struct {
 	sync.Mutex
-	hdl      Handler
-	cmd      Command
-	data     any
-	done     *flag
-	pending  chan bool
-	notify   *flag
-	listener func(as *Async)
-	err      error
-}
-
-func newAsync(hdl Handler, cmd Command) *Async {
-	return &Async{
-		hdl:     hdl,
-		cmd:     cmd,
-		done:    newFlag(),
-		notify:  newFlag(),
-		pending: make(chan bool, 1),
+	ctx         context.Context
+	cmd         Command
+	envelope    *Envelope
+	scheduleKey uuid.UUID
+	data        any
+	claimed     *flag
+	done        *flag
+	pending     chan bool
+	listeners   []func(as *Async)
+	err         error
+	attempt     int
+	issuedAt    time.Time
+	queuedAt    time.Time
+	priority    Priority
+}
+
+func newAsync(ctx context.Context, env *Envelope, issuedAt time.Time) *Async {
+	as := &Async{
+		cmd:      env.Command,
+		envelope: env,
+		claimed:  newFlag(),
+		done:     newFlag(),
+		pending:  make(chan bool),
+		attempt:  1,
+		issuedAt: issuedAt,
+		priority: priorityOf(env.Command),
 	}
+	as.ctx = withExecution(ctx, &execution{async: as})
+	return as
 }
 
 // Await for the command to be processed.
 func (as *Async) Await() (any, error) {
 	as.await()
-	if as.err != nil {
-		return nil, as.err
+	return as.result()
+}
+
+// AwaitContext waits for the command to be processed or for the context to be done.
+// If the context is done first, AwaitTimeoutError or AwaitCanceledError is returned accordingly.
+// The command itself is not affected by the context, use Cancel to prevent it from being processed.
+func (as *Async) AwaitContext(ctx context.Context) (any, error) {
+	if !as.done.enabled() {
+		select {
+		case <-as.pending:
+		case <-ctx.Done():
+			if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+				return nil, AwaitTimeoutError
+			}
+			return nil, AwaitCanceledError
+		}
 	}
-	return as.data, nil
+	return as.result()
+}
+
+// AwaitTimeout waits for the command to be processed for at most the provided duration.
+// If the duration elapses first, AwaitTimeoutError is returned.
+func (as *Async) AwaitTimeout(d time.Duration) (any, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), d)
+	defer cancel()
+	return as.AwaitContext(ctx)
+}
+
+// Envelope returns the envelope of the command.
+func (as *Async) Envelope() *Envelope {
+	return as.envelope
+}
+
+// Cancel prevents the command from being processed if it was not yet picked up by a worker.
+// A canceled *Async is resolved with CommandCanceledError.
+// It returns false if the command is already being processed (or was processed).
+func (as *Async) Cancel() bool {
+	if !as.claim() {
+		return false
+	}
+	as.fail(CommandCanceledError)
+	return true
 }
 
 //------Internal------//
@@ -42,10 +98,23 @@ func (as *Async) await() {
 	}
 }
 
+func (as *Async) result() (any, error) {
+	if as.err != nil {
+		return nil, as.err
+	}
+	return as.data, nil
+}
+
+// claim grants exclusive ownership over the execution of the command.
+// Only one of the worker or Cancel may claim the *Async.
+func (as *Async) claim() bool {
+	return as.claimed.enable()
+}
+
 func (as *Async) notifyDone() {
 	if as.done.enable() {
-		as.notifyListener()
-		as.pending <- true
+		as.notifyListeners()
+		close(as.pending)
 	}
 }
 
@@ -63,21 +132,36 @@ func (as *Async) success(data any) {
 	as.Unlock()
 }
 
-func (as *Async) setListener(listener func(as *Async)) {
+// follow resolves the *Async with the outcome of the leader, a duplicate whose execution it shares.
+// Only the follower is affected if it is canceled.
+func (as *Async) follow(leader *Async) {
+	leader.addListener(func(leader *Async) {
+		if !as.claim() {
+			return
+		}
+		if data, err := leader.result(); err != nil {
+			as.fail(err)
+		} else {
+			as.success(data)
+		}
+	})
+}
+
+// addListener registers a function to be invoked once the command is processed.
+// It is invoked immediately if the command was already processed.
+func (as *Async) addListener(listener func(as *Async)) {
 	as.Lock()
+	defer as.Unlock()
 	if as.done.enabled() {
 		listener(as)
 		return
 	}
-	if as.notify.enable() {
-		as.listener = listener
-	}
-	as.Unlock()
-
+	as.listeners = append(as.listeners, listener)
 }
 
-func (as *Async) notifyListener() {
-	if as.notify.disable() {
-		as.listener(as)
+func (as *Async) notifyListeners() {
+	for _, listener := range as.listeners {
+		listener(as)
 	}
+	as.listeners = nil
 }
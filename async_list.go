@@ -46,7 +46,7 @@ func (asl *AsyncList) AwaitIterator() (<-chan AsyncResult, error) {
 	processed := newCounter()
 	total := uint32(len(asl.cmds))
 	for i := 0; i < len(asl.cmds); i++ {
-		asl.cmds[i].setListener(asl.generateListener(i, results, processed, total))
+		asl.cmds[i].addListener(asl.generateListener(i, results, processed, total))
 	}
 	return results, nil
 }
@@ -0,0 +1,132 @@
+package command
+
+import "time"
+
+// starvationInterval determines how often the workers favor the lower priorities.
+// Every starvationInterval dequeues, the queue levels are checked in reverse order, preventing starvation.
+const starvationInterval = 10
+
+// asyncQueue is a queue of async commands with a buffered channel per priority level.
+type asyncQueue struct {
+	levels   [PriorityHigh + 1]chan *Async
+	dequeued *counter
+}
+
+func newAsyncQueue(buffer int) *asyncQueue {
+	q := &asyncQueue{
+		dequeued: newCounter(),
+	}
+	for i := range q.levels {
+		q.levels[i] = make(chan *Async, buffer)
+	}
+	return q
+}
+
+func (q *asyncQueue) push(async *Async) {
+	q.levels[q.level(async.priority)] <- async
+}
+
+func (q *asyncQueue) tryPush(async *Async) bool {
+	select {
+	case q.levels[q.level(async.priority)] <- async:
+		return true
+	default:
+		return false
+	}
+}
+
+func (q *asyncQueue) pushTimeout(async *Async, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case q.levels[q.level(async.priority)] <- async:
+		return true
+	case <-timer.C:
+		return false
+	}
+}
+
+// dropOldest removes the oldest async command with the same priority as the provided one.
+func (q *asyncQueue) dropOldest(priority Priority) *Async {
+	select {
+	case async := <-q.levels[q.level(priority)]:
+		return async
+	default:
+		return nil
+	}
+}
+
+// pop retrieves the following async command respecting the priorities.
+// It blocks until a command is available or either channel is signaled. It returns false once stop is signaled and nil
+// if woken up by the wake channel.
+func (q *asyncQueue) pop(stop <-chan bool, wake <-chan bool) (*Async, bool) {
+	starving := q.dequeued.increment()%starvationInterval == 0
+	for i := range q.levels {
+		level := len(q.levels) - 1 - i
+		if starving {
+			level = i
+		}
+		select {
+		case async := <-q.levels[level]:
+			return async, true
+		default:
+		}
+	}
+	select {
+	case async := <-q.levels[PriorityHigh]:
+		return async, true
+	case async := <-q.levels[PriorityNormal]:
+		return async, true
+	case async := <-q.levels[PriorityLow]:
+		return async, true
+	case <-wake:
+		return nil, true
+	case <-stop:
+		return nil, false
+	}
+}
+
+// tryPop retrieves the following async command without blocking, returning nil if the queue is empty.
+func (q *asyncQueue) tryPop() *Async {
+	for i := range q.levels {
+		select {
+		case async := <-q.levels[len(q.levels)-1-i]:
+			return async
+		default:
+		}
+	}
+	return nil
+}
+
+func (q *asyncQueue) length() int {
+	length := 0
+	for _, level := range q.levels {
+		length += len(level)
+	}
+	return length
+}
+
+// hasRoom reports whether every priority level has room for another command.
+func (q *asyncQueue) hasRoom() bool {
+	for _, level := range q.levels {
+		if len(level) == cap(level) {
+			return false
+		}
+	}
+	return true
+}
+
+// capacity returns the buffer size of each priority level.
+func (q *asyncQueue) capacity() int {
+	return cap(q.levels[PriorityNormal])
+}
+
+func (q *asyncQueue) level(priority Priority) Priority {
+	if priority < PriorityLow {
+		return PriorityLow
+	}
+	if priority > PriorityHigh {
+		return PriorityHigh
+	}
+	return priority
+}
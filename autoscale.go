@@ -0,0 +1,21 @@
+package command
+
+import "time"
+
+// AutoscalePolicy describes how the worker pool is automatically resized.
+// Every Interval, the pool grows by one worker (up to MaxWorkers) if the queue holds at least QueueThreshold commands,
+// or if any command waited in the queue at least WaitThreshold. A zero threshold is disregarded.
+// The pool shrinks by one worker (down to MinWorkers) for every IdleTimeout elapsed with an empty queue.
+type AutoscalePolicy struct {
+	MinWorkers     int
+	MaxWorkers     int
+	QueueThreshold int
+	WaitThreshold  time.Duration
+	IdleTimeout    time.Duration
+	Interval       time.Duration
+}
+
+func (policy AutoscalePolicy) shouldGrow(depth int, wait time.Duration) bool {
+	return (policy.QueueThreshold > 0 && depth >= policy.QueueThreshold) ||
+		(policy.WaitThreshold > 0 && wait >= policy.WaitThreshold)
+}
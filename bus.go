@@ -1,7 +1,14 @@
 package command
 
 import (
+	"context"
+	"errors"
 	"runtime"
+	"runtime/debug"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/google/uuid"
 	"github.com/io-da/schedule"
@@ -10,61 +17,151 @@ import (
 // Bus is the only struct exported and required for the command bus usage.
 // The Bus should be instantiated using the NewBus function.
 type Bus struct {
-	workerPoolSize     int
-	queueBuffer        int
-	initialized        *flag
-	shuttingDown       *flag
-	workers            *counter
-	handlers           map[Identifier]Handler
-	errorHandlers      []ErrorHandler
-	middlewares        []Middleware
-	asyncCommandsQueue chan *Async
-	closed             chan bool
-	scheduleProcessor  *scheduleProcessor
+	workerPoolSize    int
+	queueBuffer       int
+	state             atomic.Uint32
+	lifecycleMutex    sync.Mutex
+	onStart           []Hook
+	onStopping        []Hook
+	onStopped         []Hook
+	handlers          map[Identifier]ContextHandler
+	handlersMutex     sync.RWMutex
+	errorHandlers     []ErrorHandler
+	middlewares       []ContextMiddleware
+	autoscalePolicy   *AutoscalePolicy
+	overflowPolicy    OverflowPolicy
+	workerPool        *workerPool
+	poolConfigs       map[string]*poolConfig
+	pools             map[Identifier]*workerPool
+	poolsMutex        sync.RWMutex
+	scheduleProcessor *scheduleProcessor
+	deadLetterStore   DeadLetterStore
+	scheduleStore     ScheduleStore
+	misfirePolicy     MisfirePolicy
+	overlapPolicy     OverlapPolicy
+	clock             Clock
+	recoverPanics     bool
+	defaultTimeout    time.Duration
+	timeouts          map[Identifier]time.Duration
+	inFlight          *inFlight
 }
 
 // NewBus instantiates the Bus struct.
-// The Initialization of the Bus is performed separately (Initialize function) for dependency injection purposes.
+// The Initialization of the Bus is performed separately (Initialize or Start functions) for dependency injection purposes.
 func NewBus() *Bus {
 	bus := &Bus{
 		workerPoolSize: runtime.GOMAXPROCS(0),
 		queueBuffer:    100,
-		initialized:    newFlag(),
-		shuttingDown:   newFlag(),
-		workers:        newCounter(),
-		handlers:       make(map[Identifier]Handler),
+		handlers:       make(map[Identifier]ContextHandler),
 		errorHandlers:  make([]ErrorHandler, 0),
-		middlewares:    make([]Middleware, 0),
-		closed:         make(chan bool),
+		middlewares:    make([]ContextMiddleware, 0),
+		poolConfigs:    make(map[string]*poolConfig),
+		pools:          make(map[Identifier]*workerPool),
+		timeouts:       make(map[Identifier]time.Duration),
+		inFlight:       newInFlight(),
+		recoverPanics:  true,
+		clock:          SystemClock{},
 	}
 	bus.scheduleProcessor = newScheduleProcessor(bus)
 	return bus
 }
 
 // SetWorkerPoolSize may optionally be used to tweak the worker pool size for async commands.
-// It can only be adjusted *before* the bus is initialized.
+// It can only be adjusted *before* the bus is started.
 // It defaults to the value returned by runtime.GOMAXPROCS(0).
 func (bus *Bus) SetWorkerPoolSize(workerPoolSize int) {
-	if !bus.initialized.enabled() {
+	if bus.configurable() {
 		bus.workerPoolSize = workerPoolSize
 	}
 }
 
+// SetAutoscalePolicy may optionally be used to automatically resize the worker pool according to the load.
+// The worker pool initially uses the size set with SetWorkerPoolSize, but it is kept within the policy limits.
+// It can only be adjusted *before* the bus is started.
+func (bus *Bus) SetAutoscalePolicy(policy AutoscalePolicy) {
+	if bus.configurable() {
+		if policy.Interval <= 0 {
+			policy.Interval = time.Second
+		}
+		bus.autoscalePolicy = &policy
+	}
+}
+
+// ResizeWorkerPool adjusts the number of workers, also while the bus is running.
+// When shrinking, the retired workers finish their current command first and the function blocks until they do.
+// Sizes lower than 1 are disregarded.
+func (bus *Bus) ResizeWorkerPool(workerPoolSize int) {
+	if workerPoolSize < 1 {
+		return
+	}
+	bus.poolsMutex.Lock()
+	pool := bus.workerPool
+	if bus.configurable() || pool == nil {
+		bus.workerPoolSize = workerPoolSize
+		bus.poolsMutex.Unlock()
+		return
+	}
+	bus.poolsMutex.Unlock()
+	pool.resize(workerPoolSize)
+}
+
+// WorkerPoolSize returns the number of workers currently running.
+func (bus *Bus) WorkerPoolSize() int {
+	bus.poolsMutex.RLock()
+	pool := bus.workerPool
+	bus.poolsMutex.RUnlock()
+	if pool == nil {
+		return 0
+	}
+	return pool.currentSize()
+}
+
+// SetPool may optionally be used to process the async commands with the provided identifiers in a dedicated worker pool.
+// Each pool has its own workers and queue, preventing floods of certain commands from starving the others.
+// Commands not assigned to any pool are processed by the default worker pool.
+// Like SetQueueBuffer, the queue buffer of the pool applies to each priority level.
+// Setting a pool with an existing name replaces its previous configuration.
+// Pools may only be provided *before* the bus is started.
+func (bus *Bus) SetPool(name string, workerPoolSize int, queueBuffer int, identifiers ...Identifier) {
+	if bus.configurable() {
+		bus.poolConfigs[name] = &poolConfig{
+			workerPoolSize: workerPoolSize,
+			queueBuffer:    queueBuffer,
+			identifiers:    identifiers,
+		}
+	}
+}
+
+// SetOverflowPolicy may optionally be used to determine how async commands are handled when the queue is full.
+// The policy applies to the default worker pool and every pool set with SetPool.
+// It can only be adjusted *before* the bus is started.
+// It defaults to OverflowBlock.
+func (bus *Bus) SetOverflowPolicy(policy OverflowPolicy) {
+	if bus.configurable() {
+		if policy.Strategy == OverflowSpill && policy.Store == nil {
+			policy.Store = NewMemoryOverflowStore()
+		}
+		bus.overflowPolicy = policy
+	}
+}
+
 // SetQueueBuffer may optionally be used to tweak the buffer size of the async commands queue.
+// The buffer applies to each priority level, so up to three times as many commands may be queued in total.
+// The queue is considered full by the overflow policy once the level of the command being queued is full.
 // This value may have high impact on performance depending on the use case.
-// It can only be adjusted *before* the bus is initialized.
+// It can only be adjusted *before* the bus is started.
 // It defaults to 100.
 func (bus *Bus) SetQueueBuffer(queueBuffer int) {
-	if !bus.initialized.enabled() {
+	if bus.configurable() {
 		bus.queueBuffer = queueBuffer
 	}
 }
 
 // SetErrorHandlers may optionally be used to provide a list of error handlers.
 // They will receive any error thrown during the command process.
-// Error handlers may only be provided *before* the bus is initialized.
+// Error handlers may only be provided *before* the bus is started.
 func (bus *Bus) SetErrorHandlers(hdls ...ErrorHandler) {
-	if !bus.initialized.enabled() {
+	if bus.configurable() {
 		bus.errorHandlers = hdls
 	}
 }
@@ -78,74 +175,350 @@ func (bus *Bus) SetErrorHandlers(hdls ...ErrorHandler) {
 //	 return next(cmd)
 //	}
 //
+// Middlewares implementing ContextMiddleware will instead be provided the context through HandleContext.
 // *The order in which the middlewares are provided to the Bus is always respected*.
-// Middlewares may only be provided *before* the bus is initialized.
+// Middlewares may only be provided *before* the bus is started.
 func (bus *Bus) SetMiddlewares(mdls ...Middleware) {
-	if !bus.initialized.enabled() {
-		bus.middlewares = mdls
+	if bus.configurable() {
+		bus.middlewares = make([]ContextMiddleware, len(mdls))
+		for i, mdl := range mdls {
+			bus.middlewares[i] = newContextMiddleware(mdl)
+		}
+	}
+}
+
+// SetPanicRecovery may optionally be used to disable the recovery of panics occurring while handling commands.
+// When enabled, panics are converted to a *PanicError, which is returned and passed on to the error handlers.
+// It can only be adjusted *before* the bus is started.
+// It defaults to true.
+func (bus *Bus) SetPanicRecovery(enabled bool) {
+	if bus.configurable() {
+		bus.recoverPanics = enabled
+	}
+}
+
+// SetDefaultTimeout may optionally be used to limit the time commands may take to be processed.
+// Once the timeout elapses, the context provided to the middlewares and the handler is canceled and TimeoutError is
+// returned to the caller, releasing the worker. The handler itself is not interrupted, it should respect the context.
+// The default timeout may be overridden per command using SetTimeout or by implementing the Timeouter interface.
+// It can only be adjusted *before* the bus is started.
+// It defaults to 0 (no timeout).
+func (bus *Bus) SetDefaultTimeout(timeout time.Duration) {
+	if bus.configurable() {
+		bus.defaultTimeout = timeout
+	}
+}
+
+// SetTimeout may optionally be used to override the default timeout of the commands with the provided identifiers.
+// A zero duration disables the timeout of these commands.
+// It can only be adjusted *before* the bus is started.
+func (bus *Bus) SetTimeout(timeout time.Duration, identifiers ...Identifier) {
+	if bus.configurable() {
+		for _, identifier := range identifiers {
+			bus.timeouts[identifier] = timeout
+		}
+	}
+}
+
+// SetDeadLetterStore may optionally be used to provide a store for the async and scheduled commands that fail.
+// Failed commands are stored as *DeadLetter and may later be redriven using the Redrive function.
+// The dead letter store may only be provided *before* the bus is started.
+func (bus *Bus) SetDeadLetterStore(store DeadLetterStore) {
+	if bus.configurable() {
+		bus.deadLetterStore = store
+	}
+}
+
+// SetMisfirePolicy may optionally be used to determine how the missed occurrences of scheduled commands are handled.
+// The policy is applied every time the scheduler issues commands late, including after persisted commands are reloaded.
+// Scheduled commands may override it by implementing the Misfirer interface.
+// It can only be adjusted *before* the bus is started.
+// It defaults to MisfireFireOnce.
+func (bus *Bus) SetMisfirePolicy(policy MisfirePolicy) {
+	if bus.configurable() {
+		bus.misfirePolicy = policy
 	}
 }
 
-// Initialize the command bus by providing the list of handlers.
-// There can only be one handler per command.
+// SetOverlapPolicy may optionally be used to determine how recurring scheduled commands are issued while their previous
+// run is still pending or being processed, preventing long-running runs from stacking up on the workers.
+// Scheduled commands may override it by implementing the Overlapper interface.
+// It can only be adjusted *before* the bus is started.
+// It defaults to OverlapAllow.
+func (bus *Bus) SetOverlapPolicy(policy OverlapPolicy) {
+	if bus.configurable() {
+		bus.overlapPolicy = policy
+	}
+}
+
+// SetClock may optionally be used to provide the clock used by the scheduler, the timeouts and the recorded timestamps.
+// It is also available to the middlewares through Clock, the middlewares not created with the bus accept a clock of their own.
+// The autoscaling of the workers and OverflowBlockTimeout always rely on the system clock.
+// It is intended for tests, which may control the time using the fake clock of github.com/io-da/command/clocktest.
+// It can only be adjusted *before* the bus is started.
+// It defaults to SystemClock.
+func (bus *Bus) SetClock(clock Clock) {
+	if bus.configurable() {
+		bus.clock = clock
+	}
+}
+
+// Clock returns the clock used by the bus.
+func (bus *Bus) Clock() Clock {
+	return bus.clock
+}
+
+// SetScheduleStore may optionally be used to provide a store for the scheduled commands, allowing them to survive restarts.
+// Only the commands implementing PersistentSchedule are persisted. The persisted commands are reloaded with their
+// original keys every time the bus is started.
+// The schedule store may only be provided *before* the bus is started.
+func (bus *Bus) SetScheduleStore(store ScheduleStore) {
+	if bus.configurable() {
+		bus.scheduleStore = store
+	}
+}
+
+// Initialize the command bus by providing the list of handlers and starts it.
+// There can only be one handler per command, including the handlers previously registered with Register.
+// Handlers that are already registered may be provided once again, allowing a stopped bus to be initialized once again.
+// Handlers implementing ContextHandler will be provided the context of the commands through HandleContext.
+// Initializing a bus that is already running has no effect, while it is shutting down it fails with BusIsShuttingDownError.
 func (bus *Bus) Initialize(hdls ...Handler) error {
-	if bus.initialized.enable() {
-		closureHandlerProvided := false
-		for _, hdl := range hdls {
-			if _, exists := bus.handlers[hdl.Handles()]; exists {
-				return OneHandlerPerCommandError
-			}
-			bus.handlers[hdl.Handles()] = hdl
-			if hdl.Handles() == ClosureIdentifier {
-				closureHandlerProvided = true
-			}
+	switch bus.State() {
+	case StateStarting, StateRunning:
+		return nil
+	case StateStopping:
+		return BusIsShuttingDownError
+	}
+	bus.handlersMutex.Lock()
+	handlers := make(map[Identifier]ContextHandler, len(bus.handlers))
+	for identifier, hdl := range bus.handlers {
+		if !slices.ContainsFunc(hdls, func(provided Handler) bool { return isHandler(hdl, provided) }) {
+			handlers[identifier] = hdl
 		}
-		if !closureHandlerProvided {
-			hdl := &ClosureHandler{}
-			bus.handlers[hdl.Handles()] = hdl
+	}
+	err := addHandlers(handlers, hdls)
+	if err == nil {
+		bus.handlers = handlers
+	}
+	bus.handlersMutex.Unlock()
+	if err != nil {
+		return err
+	}
+	return bus.Start()
+}
+
+// Start the command bus using the handlers registered so far.
+// The OnStart hooks are invoked before the workers are started, while the bus settings may still be adjusted.
+// If any of the hooks fails, the bus remains stopped and the error is returned.
+// Starting a bus that is not stopped fails with BusIsRunningError or BusIsShuttingDownError.
+func (bus *Bus) Start() error {
+	if !bus.state.CompareAndSwap(uint32(StateStopped), uint32(StateStarting)) {
+		if bus.State() == StateStopping {
+			return BusIsShuttingDownError
 		}
-		bus.asyncCommandsQueue = make(chan *Async, bus.queueBuffer)
-		for i := 0; i < bus.workerPoolSize; i++ {
-			bus.workers.increment()
-			go bus.worker(bus.asyncCommandsQueue, bus.closed)
+		return BusIsRunningError
+	}
+	for _, hook := range bus.hooks(&bus.onStart) {
+		if err := hook(bus); err != nil {
+			bus.state.Store(uint32(StateStopped))
+			return err
 		}
 	}
+	if err := bus.scheduleProcessor.reload(); err != nil {
+		bus.state.Store(uint32(StateStopped))
+		return err
+	}
+	bus.start()
+	bus.state.Store(uint32(StateRunning))
 	return nil
 }
 
+// Stop the command bus gracefully, blocking until it is fully stopped or the context is done.
+// The OnStopping hooks are invoked once the bus stops accepting commands, the OnStopped hooks once it is fully stopped.
+// The schedule processor is stopped and the queued async commands are still processed, unless the context is done first.
+// In which case they are abandoned, failing with BusIsShuttingDownError, and a *ShutdownError is returned with the number
+// of abandoned commands. Commands being processed are always allowed to complete, but they are no longer awaited once
+// the context is done.
+// Registered handlers and scheduled commands are kept, the bus may be started again once stopped.
+func (bus *Bus) Stop(ctx context.Context) error {
+	if !bus.state.CompareAndSwap(uint32(StateRunning), uint32(StateStopping)) {
+		if bus.State() == StateStopping {
+			return BusIsShuttingDownError
+		}
+		return BusNotInitializedError
+	}
+	return bus.shutdown(ctx)
+}
+
+// Restart stops the command bus gracefully and starts it once again.
+// If the bus could not be fully stopped before the context is done, the error is returned and the bus is not started.
+func (bus *Bus) Restart(ctx context.Context) error {
+	if err := bus.Stop(ctx); err != nil {
+		return err
+	}
+	return bus.Start()
+}
+
+// State returns the current lifecycle state of the bus.
+func (bus *Bus) State() State {
+	return State(bus.state.Load())
+}
+
+// OnStart registers hooks to be invoked every time the bus is starting.
+func (bus *Bus) OnStart(hooks ...Hook) {
+	bus.addHooks(&bus.onStart, hooks)
+}
+
+// OnStopping registers hooks to be invoked every time the bus is stopping, before the queued commands are drained.
+func (bus *Bus) OnStopping(hooks ...Hook) {
+	bus.addHooks(&bus.onStopping, hooks)
+}
+
+// OnStopped registers hooks to be invoked every time the bus is fully stopped.
+func (bus *Bus) OnStopped(hooks ...Hook) {
+	bus.addHooks(&bus.onStopped, hooks)
+}
+
+// Register provides the bus additional handlers, before or after it is started.
+// There can only be one handler per command, if any of the handlers conflicts none of them are registered.
+// Commands already queued will be processed by the newly registered handlers.
+func (bus *Bus) Register(hdls ...Handler) error {
+	bus.handlersMutex.Lock()
+	defer bus.handlersMutex.Unlock()
+	return addHandlers(bus.handlers, hdls)
+}
+
+// Unregister removes the handlers of the commands with the provided identifiers.
+// Commands currently being processed are not affected, they complete using the handler they started with.
+// Queued async commands and scheduled commands that are no longer handled fail with HandlerNotFoundError.
+// Scheduled commands are kept, allowing them to be processed again once a handler is registered.
+func (bus *Bus) Unregister(identifiers ...Identifier) {
+	bus.handlersMutex.Lock()
+	for _, identifier := range identifiers {
+		delete(bus.handlers, identifier)
+	}
+	bus.handlersMutex.Unlock()
+}
+
+// Replace registers the handler, replacing the current handler of the same command (if any).
+// Commands currently being processed complete using the previous handler, while queued commands use the new one.
+func (bus *Bus) Replace(hdl Handler) {
+	bus.handlersMutex.Lock()
+	bus.handlers[hdl.Handles()] = newContextHandler(hdl)
+	bus.handlersMutex.Unlock()
+}
+
 // Handle processes the command synchronously through their respective handler.
 func (bus *Bus) Handle(cmd Command) (any, error) {
-	hdl, err := bus.getHandler(cmd)
+	return bus.HandleContext(context.Background(), cmd)
+}
+
+// HandleContext processes the command synchronously through their respective handler.
+// The provided context is propagated through the middlewares and the handler.
+func (bus *Bus) HandleContext(ctx context.Context, cmd Command) (any, error) {
+	ctx, env := envelop(withExecution(ctx, &execution{}), cmd, bus.clock.Now())
+	cmd = env.Command
+	hdl, err := bus.getHandler(ctx, cmd)
 	if err != nil {
 		return nil, err
 	}
-	return bus.handle(hdl, cmd)
+	if _, ok := cmd.(Coalescable); ok {
+		return bus.handleCoalesced(ctx, env, hdl)
+	}
+	return bus.handle(ctx, hdl, cmd)
 }
 
 // HandleAsync processes the command asynchronously using workers through their respective handler.
 // It also returns an *Async struct which allows clients to optionally ```Await``` for the command to be processed.
 func (bus *Bus) HandleAsync(cmd Command) (*Async, error) {
-	async, err := bus.prepareAsync(cmd)
+	return bus.HandleAsyncContext(context.Background(), cmd)
+}
+
+// HandleAsyncContext processes the command asynchronously using workers through their respective handler.
+// The provided context is propagated through the middlewares and the handler.
+// If the context is done before a worker picks up the command, the command is not handled and the *Async fails with the context error.
+// When issued with the context of another command being processed, the command is not canceled along with it.
+func (bus *Bus) HandleAsyncContext(ctx context.Context, cmd Command) (*Async, error) {
+	async, err := bus.prepareAsync(ctx, cmd)
 	if err != nil {
 		return nil, err
 	}
-	bus.asyncCommandsQueue <- async
+	if existing, ok := bus.inFlight.coalesce(async); ok {
+		return existing, nil
+	}
+	if err = bus.submit(async); err != nil {
+		return nil, err
+	}
+	return async, nil
+}
+
+// TryHandleAsync processes the command asynchronously, like HandleAsync, but never blocks.
+// If the queue is full, QueueFullError is returned regardless of the overflow policy.
+func (bus *Bus) TryHandleAsync(cmd Command) (*Async, error) {
+	async, err := bus.prepareAsync(context.Background(), cmd)
+	if err != nil {
+		return nil, err
+	}
+	if existing, ok := bus.inFlight.coalesce(async); ok {
+		return existing, nil
+	}
+	if err = bus.poolFor(async.cmd.Identifier()).tryEnqueue(async); err != nil {
+		bus.discard(async, err)
+		return nil, err
+	}
+	return async, nil
+}
+
+// HandleAsyncWithPriority processes the command asynchronously with the provided priority.
+// Queued commands with higher priority are processed first, while lower priorities are still periodically favored to prevent starvation.
+// Commands may alternatively implement the Prioritized interface, which is also respected by scheduled commands.
+func (bus *Bus) HandleAsyncWithPriority(cmd Command, priority Priority) (*Async, error) {
+	async, err := bus.prepareAsync(context.Background(), cmd)
+	if err != nil {
+		return nil, err
+	}
+	async.priority = priority
+	if existing, ok := bus.inFlight.coalesce(async); ok {
+		return existing, nil
+	}
+	if err = bus.submit(async); err != nil {
+		return nil, err
+	}
 	return async, nil
 }
 
 // HandleAsyncList processes the provided commands asynchronously using workers through their respective handler.
 // It also returns an *AsyncList struct which allows clients to optionally ```Await``` for the commands respectively.
 func (bus *Bus) HandleAsyncList(cmds ...Command) (*AsyncList, error) {
+	return bus.HandleAsyncListContext(context.Background(), cmds...)
+}
+
+// HandleAsyncListContext processes the provided commands asynchronously using workers through their respective handler.
+// The provided context is propagated to every command of the list.
+func (bus *Bus) HandleAsyncListContext(ctx context.Context, cmds ...Command) (*AsyncList, error) {
 	asl := &AsyncList{make([]*Async, len(cmds))}
 	for i, cmd := range cmds {
-		async, err := bus.prepareAsync(cmd)
+		async, err := bus.prepareAsync(ctx, cmd)
 		if err != nil {
 			return nil, err
 		}
 		asl.cmds[i] = async
 	}
-	for _, async := range asl.cmds {
-		bus.asyncCommandsQueue <- async
+	submitted := make([]*Async, 0, len(asl.cmds))
+	for i, async := range asl.cmds {
+		if existing, ok := bus.inFlight.coalesce(async); ok {
+			asl.cmds[i] = existing
+			continue
+		}
+		if err := bus.submit(async); err != nil {
+			// the list is processed entirely or not at all
+			for _, queued := range submitted {
+				queued.Cancel()
+			}
+			return nil, err
+		}
+		submitted = append(submitted, async)
 	}
 	return asl, nil
 }
@@ -153,11 +526,23 @@ func (bus *Bus) HandleAsyncList(cmds ...Command) (*AsyncList, error) {
 // Schedule allows commands to be scheduled to be executed asynchronously.
 // Check https://github.com/io-da/schedule for ```*Schedule``` usage.
 func (bus *Bus) Schedule(cmd Command, sch *schedule.Schedule) (*uuid.UUID, error) {
-	hdl, err := bus.getHandler(cmd)
+	return bus.ScheduleContext(context.Background(), cmd, sch)
+}
+
+// ScheduleContext allows commands to be scheduled to be executed asynchronously.
+// The provided context is propagated to every execution of the command.
+// Once the context is done, the command is automatically removed from the schedule.
+func (bus *Bus) ScheduleContext(ctx context.Context, cmd Command, sch *schedule.Schedule) (*uuid.UUID, error) {
+	if _, err := bus.getHandler(withExecution(ctx, &execution{scheduled: true}), unwrap(cmd)); err != nil {
+		return nil, err
+	}
+	key, err := bus.scheduleProcessor.add(newScheduledCommand(ctx, cmd, sch))
 	if err != nil {
 		return nil, err
 	}
-	key := bus.scheduleProcessor.add(newScheduledCommand(hdl, cmd, sch))
+	context.AfterFunc(ctx, func() {
+		bus.scheduleProcessor.remove(key)
+	})
 	return &key, nil
 }
 
@@ -166,103 +551,544 @@ func (bus *Bus) RemoveScheduled(keys ...uuid.UUID) {
 	bus.scheduleProcessor.remove(keys...)
 }
 
-// Shutdown the command bus gracefully.
-// *Async commands accessed while shutting down will be disregarded*.
+// Scheduled lists the scheduled commands, ordered by the time they were scheduled.
+func (bus *Bus) Scheduled() []*ScheduledEntry {
+	return bus.scheduleProcessor.entries()
+}
+
+// InspectScheduled describes the scheduled command with the provided key.
+// ScheduledCommandNotFoundError is returned if the command is not scheduled (anymore).
+func (bus *Bus) InspectScheduled(key uuid.UUID) (*ScheduledEntry, error) {
+	return bus.scheduleProcessor.inspect(key)
+}
+
+// PauseScheduled stops issuing the scheduled commands until they are resumed.
+func (bus *Bus) PauseScheduled(keys ...uuid.UUID) {
+	bus.scheduleProcessor.pause(keys...)
+}
+
+// ResumeScheduled resumes issuing previously paused scheduled commands.
+// The occurrences that were due while the commands were paused are skipped.
+func (bus *Bus) ResumeScheduled(keys ...uuid.UUID) {
+	bus.scheduleProcessor.resume(keys...)
+}
+
+// RescheduleScheduled replaces the schedule of the scheduled command with the provided key.
+// Only the next run of the new schedule is persisted, reloaded commands resume with the schedule they provide.
+// ScheduledCommandNotFoundError is returned if the command is not scheduled (anymore).
+func (bus *Bus) RescheduleScheduled(key uuid.UUID, sch *schedule.Schedule) error {
+	return bus.scheduleProcessor.reschedule(key, sch)
+}
+
+// Redrive resubmits the dead lettered commands that match the filter (or all of them if nil) to be processed asynchronously.
+// The commands are removed from the dead letter store and processed through the usual pipeline.
+// If they fail once again, they are stored as a new *DeadLetter with an incremented attempt count.
+// Dead letters that can not be redriven (e.g. their handler is no longer registered) are passed on to the error handlers
+// and remain in the store, without preventing the others from being redriven.
+func (bus *Bus) Redrive(filter DeadLetterFilter) (*AsyncList, error) {
+	if bus.deadLetterStore == nil {
+		return nil, DeadLetterStoreNotSetError
+	}
+	deadLetters, err := bus.deadLetterStore.Load()
+	if err != nil {
+		return nil, err
+	}
+	asl := NewAsyncList()
+	ids := make([]uuid.UUID, 0, len(deadLetters))
+	for _, dl := range deadLetters {
+		if filter != nil && !filter(dl) {
+			continue
+		}
+		async, err := bus.prepareAsync(context.Background(), dl.Command)
+		if err == HandlerNotFoundError || err == InvalidCommandError {
+			// the dead letter alone can not be redriven, the error was already reported by the lookup
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		async.attempt = dl.Attempts + 1
+		async.issuedAt = dl.IssuedAt
+		asl.Push(async)
+		ids = append(ids, dl.ID)
+	}
+	if err = bus.deadLetterStore.Remove(ids...); err != nil {
+		return nil, err
+	}
+	for _, async := range asl.cmds {
+		if err = bus.enqueue(async); err != nil {
+			bus.reject(async, StageQueue, err)
+		}
+	}
+	return asl, nil
+}
+
+// CoalescingStats returns the metrics of the idempotent and coalescable commands that shared an execution so far.
+func (bus *Bus) CoalescingStats() CoalescingStats {
+	return bus.inFlight.stats()
+}
+
+// ReportError passes the error on to the error handlers of the bus.
+// It may be used by middlewares to report failures that do not necessarily end the processing of the command.
+func (bus *Bus) ReportError(cmd Command, err error) {
+	bus.error(context.Background(), cmd, StageReported, err)
+}
+
+// ReportErrorContext passes the error on to the error handlers of the bus, along with the envelope of the context.
+func (bus *Bus) ReportErrorContext(ctx context.Context, cmd Command, err error) {
+	bus.error(ctx, cmd, StageReported, err)
+}
+
+// Shutdown the command bus gracefully in the background.
+// The bus immediately stops accepting commands, while the queued ones are still processed.
+// Use Stop to wait for the bus to be fully stopped.
 func (bus *Bus) Shutdown() {
-	if bus.shuttingDown.enable() {
-		go bus.shutdown()
+	if bus.state.CompareAndSwap(uint32(StateRunning), uint32(StateStopping)) {
+		go func() {
+			_ = bus.shutdown(context.Background())
+		}()
 	}
 }
 
+// ShutdownContext shuts down the command bus gracefully, blocking until it is fully stopped or the context is done.
+// It is equivalent to Stop.
+func (bus *Bus) ShutdownContext(ctx context.Context) error {
+	return bus.Stop(ctx)
+}
+
 //-----Internal------//
 
-func (bus *Bus) worker(asyncCommandsQueue <-chan *Async, closed chan<- bool) {
-	for async := range asyncCommandsQueue {
-		if async == nil {
-			break
+// configurable reports whether the bus settings may still be adjusted.
+func (bus *Bus) configurable() bool {
+	state := bus.State()
+	return state == StateStopped || state == StateStarting
+}
+
+func (bus *Bus) start() {
+	// the pools are replaced on every start, while other goroutines may still be looking them up
+	bus.poolsMutex.Lock()
+	bus.workerPool = newWorkerPool(bus, bus.workerPoolSize, bus.queueBuffer)
+	bus.workerPool.autoscale = bus.autoscalePolicy
+	bus.workerPool.overflow = bus.overflowPolicy
+	bus.workerPool.start()
+	bus.pools = make(map[Identifier]*workerPool)
+	for _, cfg := range bus.poolConfigs {
+		pool := newWorkerPool(bus, cfg.workerPoolSize, cfg.queueBuffer)
+		pool.overflow = bus.overflowPolicy
+		for _, identifier := range cfg.identifiers {
+			bus.pools[identifier] = pool
+		}
+		pool.start()
+	}
+	bus.poolsMutex.Unlock()
+	bus.scheduleProcessor.start()
+}
+
+func (bus *Bus) addHooks(target *[]Hook, hooks []Hook) {
+	bus.lifecycleMutex.Lock()
+	*target = append(*target, hooks...)
+	bus.lifecycleMutex.Unlock()
+}
+
+func (bus *Bus) hooks(source *[]Hook) []Hook {
+	bus.lifecycleMutex.Lock()
+	defer bus.lifecycleMutex.Unlock()
+	return slices.Clone(*source)
+}
+
+// runHooks invokes the hooks, passing on their errors to the error handlers.
+func (bus *Bus) runHooks(source *[]Hook) {
+	for _, hook := range bus.hooks(source) {
+		if err := hook(bus); err != nil {
+			bus.error(context.Background(), nil, StageLifecycle, err)
+		}
+	}
+}
+
+func addHandlers(handlers map[Identifier]ContextHandler, hdls []Handler) error {
+	for i, hdl := range hdls {
+		if _, exists := handlers[hdl.Handles()]; exists {
+			return OneHandlerPerCommandError
+		}
+		for _, added := range hdls[:i] {
+			if added.Handles() == hdl.Handles() {
+				return OneHandlerPerCommandError
+			}
 		}
-		bus.handleAsync(async)
 	}
-	closed <- true
+	for _, hdl := range hdls {
+		handlers[hdl.Handles()] = newContextHandler(hdl)
+	}
+	return nil
 }
 
-func (bus *Bus) prepareAsync(cmd Command) (*Async, error) {
-	hdl, err := bus.getHandler(cmd)
+func (bus *Bus) poolFor(identifier Identifier) *workerPool {
+	bus.poolsMutex.RLock()
+	defer bus.poolsMutex.RUnlock()
+	if pool, ok := bus.pools[identifier]; ok {
+		return pool
+	}
+	return bus.workerPool
+}
+
+func (bus *Bus) enqueue(async *Async) error {
+	return bus.poolFor(async.cmd.Identifier()).enqueue(async)
+}
+
+// submit queues the async command, passing on any overflow error to the error handlers.
+func (bus *Bus) submit(async *Async) error {
+	err := bus.enqueue(async)
 	if err != nil {
+		bus.error(async.ctx, async.cmd, StageQueue, err)
+		bus.discard(async, err)
+	}
+	return err
+}
+
+// discard fails async commands that could not be queued, since duplicates may have been coalesced onto them.
+func (bus *Bus) discard(async *Async, err error) {
+	if async.claim() {
+		async.fail(err)
+	}
+}
+
+// reject fails async commands that no caller is directly aware of, dead lettering them.
+// It returns false if the async command was already claimed (e.g. canceled).
+func (bus *Bus) reject(async *Async, stage Stage, err error) bool {
+	if !async.claim() {
+		return false
+	}
+	bus.error(async.ctx, async.cmd, stage, err)
+	bus.deadLetter(async, err)
+	async.fail(err)
+	return true
+}
+
+// drop fails async commands dropped from a full queue.
+func (bus *Bus) drop(async *Async) {
+	bus.reject(async, StageQueue, CommandDroppedError)
+}
+
+func (bus *Bus) prepareAsync(ctx context.Context, cmd Command) (*Async, error) {
+	now := bus.clock.Now()
+	ctx, env := envelop(detach(ctx), cmd, now)
+	async := newAsync(ctx, env, now)
+	if _, err := bus.getHandler(async.ctx, async.cmd); err != nil {
 		return nil, err
 	}
-	return newAsync(hdl, cmd), nil
+	return async, nil
 }
 
 func (bus *Bus) handleAsync(async *Async) {
-	data, err := bus.handle(async.hdl, async.cmd)
+	if !async.claim() {
+		// the async was canceled while queued
+		return
+	}
+	if err := async.ctx.Err(); err != nil {
+		bus.error(async.ctx, async.cmd, StageQueue, err)
+		async.fail(err)
+		return
+	}
+	// the handler is resolved only now, since it may have been replaced or unregistered while queued
+	hdl, ok := bus.lookupHandler(async.cmd.Identifier())
+	if !ok {
+		bus.error(async.ctx, async.cmd, StageLookup, HandlerNotFoundError)
+		bus.deadLetter(async, HandlerNotFoundError)
+		async.fail(HandlerNotFoundError)
+		return
+	}
+	data, err := bus.handle(async.ctx, hdl, async.cmd)
 	if err != nil {
+		bus.deadLetter(async, err)
 		async.fail(err)
 		return
 	}
 	async.success(data)
 }
 
-func (bus *Bus) handle(hdl Handler, cmd Command) (data any, err error) {
-	data, err = bus.handleMiddlewares(hdl, cmd, 0)
+func (bus *Bus) deadLetter(async *Async, err error) {
+	if bus.deadLetterStore == nil {
+		return
+	}
+	dl := &DeadLetter{
+		ID:         uuid.New(),
+		Identifier: async.cmd.Identifier(),
+		Command:    async.cmd,
+		Err:        err,
+		Attempts:   async.attempt,
+		IssuedAt:   async.issuedAt,
+		FailedAt:   bus.clock.Now(),
+	}
+	if err = bus.deadLetterStore.Store(dl); err != nil {
+		bus.error(async.ctx, async.cmd, StageDeadLetter, err)
+	}
+}
+
+// handleCoalesced processes the command synchronously, unless an identical command is already in-flight.
+// The execution is tracked as an *Async, allowing async duplicates to share it as well.
+func (bus *Bus) handleCoalesced(ctx context.Context, env *Envelope, hdl ContextHandler) (any, error) {
+	async := newAsync(ctx, env, bus.clock.Now())
+	if _, ok := bus.inFlight.coalesce(async); ok {
+		return async.AwaitContext(ctx)
+	}
+	if !async.claim() {
+		return async.AwaitContext(ctx)
+	}
+	data, err := bus.handle(ctx, hdl, env.Command)
+	if err != nil {
+		async.fail(err)
+		return nil, err
+	}
+	async.success(data)
+	return data, nil
+}
+
+func (bus *Bus) handle(ctx context.Context, hdl ContextHandler, cmd Command) (any, error) {
+	ctx = startExecution(ctx, bus.clock)
+	timeout := bus.timeoutOf(cmd)
+	if timeout <= 0 {
+		return bus.process(ctx, hdl, cmd)
+	}
+	ctx, cancel := bus.withTimeout(ctx, timeout)
+	defer cancel()
+	results := make(chan AsyncResult, 1)
+	go func() {
+		data, err := bus.process(ctx, hdl, cmd)
+		results <- AsyncResult{Data: data, Err: err}
+	}()
+	// results arriving along with the timeout are preferred, unless they are the consequence of it
+	timedOut := func(res AsyncResult) bool {
+		return context.Cause(ctx) == TimeoutError && errors.Is(res.Err, ctx.Err())
+	}
+	select {
+	case res := <-results:
+		if !timedOut(res) {
+			return res.Get()
+		}
+	case <-ctx.Done():
+		if context.Cause(ctx) != TimeoutError {
+			// the context of the caller is done, it is up to the handler to respect it
+			return (<-results).Get()
+		}
+		select {
+		case res := <-results:
+			if !timedOut(res) {
+				return res.Get()
+			}
+		default:
+		}
+	}
+	bus.error(ctx, cmd, StageTimeout, TimeoutError)
+	return nil, TimeoutError
+}
+
+func (bus *Bus) misfirePolicyOf(cmd Command) MisfirePolicy {
+	if misfirer, ok := cmd.(Misfirer); ok {
+		return misfirer.MisfirePolicy()
+	}
+	return bus.misfirePolicy
+}
+
+func (bus *Bus) overlapPolicyOf(cmd Command) OverlapPolicy {
+	if overlapper, ok := cmd.(Overlapper); ok {
+		return overlapper.OverlapPolicy()
+	}
+	return bus.overlapPolicy
+}
+
+// withTimeout cancels the context with TimeoutError once the timeout elapses according to the clock of the bus.
+func (bus *Bus) withTimeout(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if _, ok := bus.clock.(SystemClock); ok {
+		// the deadline of the context is relative to the system clock, so it is only set when using it
+		return context.WithTimeoutCause(ctx, timeout, TimeoutError)
+	}
+	ctx, cancel := context.WithCancelCause(ctx)
+	stop := bus.clock.AfterFunc(timeout, func() {
+		cancel(TimeoutError)
+	})
+	return ctx, func() {
+		stop()
+		cancel(nil)
+	}
+}
+
+func (bus *Bus) timeoutOf(cmd Command) time.Duration {
+	if timeouter, ok := cmd.(Timeouter); ok {
+		return timeouter.Timeout()
+	}
+	if timeout, ok := bus.timeouts[cmd.Identifier()]; ok {
+		return timeout
+	}
+	return bus.defaultTimeout
+}
+
+// process runs the command through the middlewares and the handler, reporting any failure.
+func (bus *Bus) process(ctx context.Context, hdl ContextHandler, cmd Command) (data any, err error) {
+	if bus.recoverPanics {
+		defer func() {
+			if r := recover(); r != nil {
+				data = nil
+				err = &PanicError{Value: r, Stack: debug.Stack()}
+				bus.error(ctx, cmd, StagePanic, err)
+			}
+		}()
+	}
+	// the index of the middleware the error originated from, or -1 if it originated from the handler
+	origin := -1
+	data, err = bus.handleMiddlewares(ctx, hdl, cmd, 0, &origin)
 	if err != nil {
 		data = nil
-		bus.error(cmd, err)
+		if context.Cause(ctx) == TimeoutError {
+			// the command timed out, which is already reported
+			return
+		}
+		cmdErr := newCommandError(ctx, cmd, StageHandler, err)
+		if _, wrapped := err.(*CommandError); origin >= 0 && !wrapped {
+			cmdErr.Stage = StageMiddleware
+			cmdErr.MiddlewareIndex = origin
+			cmdErr.Middleware = middlewareName(bus.middlewares[origin])
+		}
+		bus.report(cmdErr)
 	}
 	return
 }
 
-func (bus *Bus) handleMiddlewares(hdl Handler, cmd Command, currentMdlIdx int) (data any, err error) {
-	if len(bus.middlewares) == 0 {
-		return hdl.Handle(cmd)
+func (bus *Bus) handleMiddlewares(ctx context.Context, hdl ContextHandler, cmd Command, currentMdlIdx int, origin *int) (data any, err error) {
+	if currentMdlIdx >= len(bus.middlewares) {
+		*origin = -1
+		return hdl.HandleContext(ctx, cmd)
 	}
-	mdl := bus.middlewares[currentMdlIdx]
-	currentMdlIdx++
-	if currentMdlIdx < len(bus.middlewares) {
-		return mdl.Handle(cmd, func(cmd Command) (any, error) {
-			return bus.handleMiddlewares(hdl, cmd, currentMdlIdx)
-		})
+	var nextErr error
+	data, err = bus.middlewares[currentMdlIdx].HandleContext(ctx, cmd, func(ctx context.Context, cmd Command) (any, error) {
+		var data any
+		data, nextErr = bus.handleMiddlewares(ctx, hdl, cmd, currentMdlIdx+1, origin)
+		return data, nextErr
+	})
+	// errors that are not (or do not wrap) the error of the next step originate from this middleware
+	if err != nil && (nextErr == nil || !errors.Is(err, nextErr)) {
+		*origin = currentMdlIdx
 	}
-	return mdl.Handle(cmd, hdl.Handle)
+	return
 }
 
-func (bus *Bus) shutdown() {
-	for !bus.workers.is(0) {
-		bus.asyncCommandsQueue <- nil
-		<-bus.closed
-		bus.workers.decrement()
+func (bus *Bus) shutdown(ctx context.Context) error {
+	bus.runHooks(&bus.onStopping)
+	select {
+	case <-bus.scheduleProcessor.shutdown():
+	case <-ctx.Done():
+	}
+	pools := bus.allPools()
+	for _, pool := range pools {
+		pool.close()
 	}
-	bus.scheduleProcessor.shutdown()
-	bus.initialized.disable()
-	bus.shuttingDown.disable()
+	abandoned := 0
+	if !bus.drain(ctx, pools) {
+		for _, pool := range pools {
+			abandoned += pool.abandon()
+		}
+	}
+
+	stopped := make(chan bool)
+	go func() {
+		<-bus.scheduleProcessor.shutdown()
+		for _, pool := range pools {
+			pool.shutdown()
+		}
+		bus.state.Store(uint32(StateStopped))
+		bus.runHooks(&bus.onStopped)
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+		if abandoned == 0 {
+			return nil
+		}
+	case <-ctx.Done():
+	}
+	return &ShutdownError{Abandoned: abandoned, Err: ctx.Err()}
 }
 
-func (bus *Bus) getHandler(cmd Command) (hdl Handler, err error) {
+// drain waits for the queued async commands to be processed, returning false if the context is done first.
+func (bus *Bus) drain(ctx context.Context, pools []*workerPool) bool {
+	ticker := time.NewTicker(time.Millisecond)
+	defer ticker.Stop()
+	for {
+		drained := true
+		for _, pool := range pools {
+			drained = drained && pool.drained()
+		}
+		if drained {
+			return true
+		}
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return false
+		}
+	}
+}
+
+func (bus *Bus) allPools() []*workerPool {
+	bus.poolsMutex.RLock()
+	defer bus.poolsMutex.RUnlock()
+	pools := make([]*workerPool, 0, len(bus.pools)+1)
+	if bus.workerPool != nil {
+		pools = append(pools, bus.workerPool)
+	}
+	for _, pool := range bus.pools {
+		if !slices.Contains(pools, pool) {
+			pools = append(pools, pool)
+		}
+	}
+	return pools
+}
+
+func (bus *Bus) getHandler(ctx context.Context, cmd Command) (hdl ContextHandler, err error) {
 	if cmd == nil {
 		err = InvalidCommandError
-		bus.error(cmd, err)
+		bus.error(ctx, cmd, StageLookup, err)
 		return
 	}
-	if !bus.initialized.enabled() {
+	switch bus.State() {
+	case StateStopped, StateStarting:
 		err = BusNotInitializedError
-		bus.error(cmd, err)
+		bus.error(ctx, cmd, StageLookup, err)
 		return
-	}
-	if bus.shuttingDown.enabled() {
+	case StateStopping:
 		err = BusIsShuttingDownError
-		bus.error(cmd, err)
+		bus.error(ctx, cmd, StageLookup, err)
 		return
 	}
-	hdl, ok := bus.handlers[cmd.Identifier()]
+	hdl, ok := bus.lookupHandler(cmd.Identifier())
 	if !ok {
 		err = HandlerNotFoundError
-		bus.error(cmd, err)
+		bus.error(ctx, cmd, StageLookup, err)
 	}
 	return
 }
 
-func (bus *Bus) error(cmd Command, err error) {
+func (bus *Bus) lookupHandler(identifier Identifier) (ContextHandler, bool) {
+	bus.handlersMutex.RLock()
+	hdl, ok := bus.handlers[identifier]
+	bus.handlersMutex.RUnlock()
+	if !ok && identifier == ClosureIdentifier {
+		// closures are always handled, by default using the ClosureHandler
+		return closureHandler, true
+	}
+	return hdl, ok
+}
+
+// error passes on the error to the error handlers, wrapped in a *CommandError.
+func (bus *Bus) error(ctx context.Context, cmd Command, stage Stage, err error) {
+	bus.report(newCommandError(ctx, cmd, stage, err))
+}
+
+func (bus *Bus) report(cmdErr *CommandError) {
 	for _, errHdl := range bus.errorHandlers {
-		errHdl.Handle(cmd, err)
+		if envErrHdl, ok := errHdl.(EnvelopeErrorHandler); ok && cmdErr.envelope != nil {
+			envErrHdl.HandleEnvelope(cmdErr.envelope, cmdErr)
+			continue
+		}
+		errHdl.Handle(cmdErr.command, cmdErr)
 	}
 }
@@ -1,11 +1,17 @@
 package command
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
+	"path/filepath"
 	"strconv"
 	"sync"
 	"testing"
 	"time"
 
+	"github.com/google/uuid"
+	"github.com/io-da/command/clocktest"
 	"github.com/io-da/schedule"
 )
 
@@ -25,6 +31,31 @@ func TestBus_Initialize(t *testing.T) {
 	if len(bus.handlers) != 2 {
 		t.Error("Unexpected number of handlers.")
 	}
+	// initializing a running bus has no effect
+	if err := bus.Initialize(hdl, &testHandler{TestLiteralCommand}); err != nil {
+		t.Error(err.Error())
+	}
+	if len(bus.handlers) != 2 {
+		t.Error("Expected the handlers of a running bus to remain the same.")
+	}
+
+	bus = NewBus()
+	if err := bus.Register(hdl); err != nil {
+		t.Fatal(err.Error())
+	}
+	if err := bus.Initialize(hdlRepeated, hdl2); err != OneHandlerPerCommandError {
+		t.Error("Expected OneHandlerPerCommandError error.")
+	}
+	if len(bus.handlers) != 1 || !isHandler(bus.handlers[TestCommand1], hdl) {
+		t.Error("Expected the registered handler to be kept.")
+	}
+	// handlers already registered may be provided once again
+	if err := bus.Initialize(hdl, hdl2); err != nil {
+		t.Fatal(err.Error())
+	}
+	if len(bus.handlers) != 2 {
+		t.Error("Unexpected number of handlers.")
+	}
 }
 
 func TestBus_AsyncBuffer(t *testing.T) {
@@ -34,11 +65,291 @@ func TestBus_AsyncBuffer(t *testing.T) {
 		t.Fatal(err.Error())
 	}
 
-	if cap(bus.asyncCommandsQueue) != 1000 {
+	if bus.workerPool.queue.capacity() != 1000 {
 		t.Error("Unexpected async command queue capacity.")
 	}
 }
 
+func TestBus_ResizeWorkerPool(t *testing.T) {
+	bus := NewBus()
+	bus.ResizeWorkerPool(2)
+	if err := bus.Initialize(&testHandler{TestCommand1}); err != nil {
+		t.Fatal(err.Error())
+	}
+	if bus.WorkerPoolSize() != 2 {
+		t.Error("Unexpected worker pool size.")
+	}
+	bus.ResizeWorkerPool(8)
+	if bus.WorkerPoolSize() != 8 {
+		t.Error("Unexpected worker pool size.")
+	}
+	bus.ResizeWorkerPool(0)
+	bus.ResizeWorkerPool(1)
+	if bus.WorkerPoolSize() != 1 {
+		t.Error("Unexpected worker pool size.")
+	}
+	timeout := setupHandleTimeout(t)
+	as, err := bus.HandleAsync(&testCommand1{})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if _, err = as.Await(); err != nil {
+		t.Fatal(err.Error())
+	}
+	timeout.Stop()
+}
+
+func TestBus_AutoscaleWorkerPool(t *testing.T) {
+	bus := NewBus()
+	bus.SetWorkerPoolSize(1)
+	bus.SetAutoscalePolicy(AutoscalePolicy{
+		MinWorkers:     1,
+		MaxWorkers:     3,
+		QueueThreshold: 1,
+		IdleTimeout:    time.Millisecond * 5,
+		Interval:       time.Millisecond,
+	})
+	hdl := newTestBlockingHandler(TestCommand1)
+	if err := bus.Initialize(hdl); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	timeout := setupHandleTimeout(t)
+	asl, err := bus.HandleAsyncList(&testCommand1{}, &testCommand1{}, &testCommand1{}, &testCommand1{})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	for bus.WorkerPoolSize() < 3 {
+		time.Sleep(time.Millisecond)
+	}
+	close(hdl.release)
+	if _, err = asl.Await(); err != nil {
+		t.Fatal(err.Error())
+	}
+	for bus.WorkerPoolSize() > 1 {
+		time.Sleep(time.Millisecond)
+	}
+	timeout.Stop()
+}
+
+func TestBus_HandleAsyncPool(t *testing.T) {
+	bus := NewBus()
+	bus.SetWorkerPoolSize(1)
+	bus.SetPool("blocking", 1, 10, TestCommand1)
+	hdl := newTestBlockingHandler(TestCommand1)
+	hdl2 := &testAsyncAwaitHandler{TestCommand2}
+
+	if err := bus.Initialize(hdl, hdl2); err != nil {
+		t.Fatal(err.Error())
+	}
+	if bus.pools[TestCommand1].queue.capacity() != 10 {
+		t.Error("Unexpected pool queue capacity.")
+	}
+	timeout := setupHandleTimeout(t)
+	asl, err := bus.HandleAsyncList(&testCommand1{}, &testCommand1{})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	<-hdl.started
+	// the default pool is not affected by the blocked pool
+	as, err := bus.HandleAsync(&testCommand2{})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if data, err := as.Await(); err != nil || data != "ok" {
+		t.Error(unexpectedDataError)
+	}
+	close(hdl.release)
+	if _, err = asl.Await(); err != nil {
+		t.Fatal(err.Error())
+	}
+	timeout.Stop()
+}
+
+func TestBus_HandleAsyncWithPriority(t *testing.T) {
+	bus := NewBus()
+	bus.SetWorkerPoolSize(1)
+	hdl := newTestBlockingHandler(TestCommand1)
+	hdl2 := &testOrderHandler{handles: TestCommand2}
+
+	if err := bus.Initialize(hdl, hdl2); err != nil {
+		t.Fatal(err.Error())
+	}
+	timeout := setupHandleTimeout(t)
+	blocking, _ := bus.HandleAsync(&testCommand1{})
+	<-hdl.started
+
+	asl := NewAsyncList()
+	for i := 0; i < 20; i++ {
+		as, _ := bus.HandleAsync(&testPrioritizedCommand{PriorityLow})
+		asl.Push(as)
+	}
+	for i := 0; i < 20; i++ {
+		as, _ := bus.HandleAsync(&testPrioritizedCommand{PriorityNormal})
+		asl.Push(as)
+	}
+	for i := 0; i < 20; i++ {
+		as, _ := bus.HandleAsyncWithPriority(&testPrioritizedCommand{PriorityHigh}, PriorityHigh)
+		asl.Push(as)
+	}
+	close(hdl.release)
+	if _, err := blocking.Await(); err != nil {
+		t.Fatal(err.Error())
+	}
+	if _, err := asl.Await(); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	// the first commands processed are of high priority, but lower priorities are not starved
+	for i, priority := range hdl2.order[:8] {
+		if priority != PriorityHigh {
+			t.Fatalf("Expected command %d to be of high priority.", i)
+		}
+	}
+	firstLow := -1
+	for i, priority := range hdl2.order {
+		if priority == PriorityLow {
+			firstLow = i
+			break
+		}
+	}
+	if firstLow < 0 || firstLow >= 40 {
+		t.Error("Expected low priority commands to be processed before all the higher priority ones.")
+	}
+	timeout.Stop()
+}
+
+func TestBus_HandleAsyncOverflow(t *testing.T) {
+	setup := func(policy OverflowPolicy) (*Bus, *testBlockingHandler, *Async, *Async) {
+		bus := NewBus()
+		bus.SetWorkerPoolSize(1)
+		bus.SetQueueBuffer(1)
+		bus.SetOverflowPolicy(policy)
+		hdl := newTestBlockingHandler(TestCommand1)
+		if err := bus.Initialize(hdl); err != nil {
+			t.Fatal(err.Error())
+		}
+		processing, _ := bus.HandleAsync(&testCommand1{})
+		<-hdl.started
+		queued, err := bus.HandleAsync(&testCommand1{})
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		return bus, hdl, processing, queued
+	}
+	timeout := setupHandleTimeout(t)
+
+	bus, hdl, _, _ := setup(OverflowPolicy{Strategy: OverflowFailFast})
+	if _, err := bus.HandleAsync(&testCommand1{}); err != QueueFullError {
+		t.Error("Expected QueueFullError error.")
+	}
+	if _, err := bus.TryHandleAsync(&testCommand1{}); err != QueueFullError {
+		t.Error("Expected QueueFullError error.")
+	}
+	if _, err := bus.HandleAsyncList(&testCommand1{}); err != QueueFullError {
+		t.Error("Expected QueueFullError error.")
+	}
+	close(hdl.release)
+
+	bus, hdl, _, _ = setup(OverflowPolicy{Strategy: OverflowBlockTimeout, Timeout: time.Millisecond})
+	if _, err := bus.HandleAsync(&testCommand1{}); err != QueueFullError {
+		t.Error("Expected QueueFullError error.")
+	}
+	close(hdl.release)
+
+	bus, hdl, _, queued := setup(OverflowPolicy{Strategy: OverflowDropOldest})
+	latest, err := bus.HandleAsync(&testCommand1{})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	close(hdl.release)
+	if _, err = queued.Await(); err != CommandDroppedError {
+		t.Error("Expected CommandDroppedError error.")
+	}
+	if _, err = latest.Await(); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	bus, hdl, processing, queued := setup(OverflowPolicy{Strategy: OverflowSpill})
+	asl := NewAsyncList(processing, queued)
+	for i := 0; i < 5; i++ {
+		as, err := bus.HandleAsync(&testCommand1{})
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		asl.Push(as)
+	}
+	if bus.workerPool.overflow.Store.Len() != 5 {
+		t.Error("Expected the commands to be spilled to the overflow store.")
+	}
+	close(hdl.release)
+	if _, err = asl.Await(); err != nil {
+		t.Fatal(err.Error())
+	}
+	if !hdl.handled.is(7) {
+		t.Error("Expected all the commands to be handled.")
+	}
+
+	// without a buffer there is nothing to drop, so it blocks until the worker is available
+	bus = NewBus()
+	bus.SetWorkerPoolSize(1)
+	bus.SetQueueBuffer(0)
+	bus.SetOverflowPolicy(OverflowPolicy{Strategy: OverflowDropOldest})
+	hdl = newTestBlockingHandler(TestCommand1)
+	if err = bus.Initialize(hdl); err != nil {
+		t.Fatal(err.Error())
+	}
+	processing, _ = bus.HandleAsync(&testCommand1{})
+	<-hdl.started
+	enqueued := make(chan *Async)
+	go func() {
+		as, _ := bus.HandleAsync(&testCommand1{})
+		enqueued <- as
+	}()
+	select {
+	case <-enqueued:
+		t.Error("Expected the command to wait for the worker.")
+	case <-time.After(10 * time.Millisecond):
+	}
+	close(hdl.release)
+	if _, err = NewAsyncList(processing, <-enqueued).Await(); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	// without a buffer the queue never has room, so the spilled commands are taken by the idle workers
+	bus = NewBus()
+	bus.SetWorkerPoolSize(1)
+	bus.SetQueueBuffer(0)
+	bus.SetOverflowPolicy(OverflowPolicy{Strategy: OverflowSpill})
+	hdl = newTestBlockingHandler(TestCommand1)
+	if err = bus.Initialize(hdl); err != nil {
+		t.Fatal(err.Error())
+	}
+	processing, _ = bus.HandleAsync(&testCommand1{})
+	<-hdl.started
+	asl = NewAsyncList(processing)
+	for i := 0; i < 3; i++ {
+		as, err := bus.HandleAsync(&testCommand1{})
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		asl.Push(as)
+	}
+	close(hdl.release)
+	if _, err = asl.Await(); err != nil {
+		t.Fatal(err.Error())
+	}
+	if as, _ := bus.HandleAsync(&testCommand1{}); as == nil {
+		t.Fatal("Expected the command to be accepted.")
+	} else if _, err = as.Await(); err != nil {
+		t.Fatal(err.Error())
+	}
+	if err = bus.Stop(context.Background()); err != nil {
+		t.Fatal(err.Error())
+	}
+	timeout.Stop()
+}
+
 func TestBus_Handle(t *testing.T) {
 	bus := NewBus()
 	hdl := &testHandler{TestCommand1}
@@ -78,6 +389,113 @@ func TestBus_Handle(t *testing.T) {
 	}
 }
 
+func TestBus_HandleContext(t *testing.T) {
+	bus := NewBus()
+	hdl := &testContextHandler{TestCommand1}
+	bus.SetMiddlewares(&testMiddleware{})
+
+	if err := bus.Initialize(hdl); err != nil {
+		t.Fatal(err.Error())
+	}
+	ctx := context.WithValue(context.Background(), testContextValueKey, "foo")
+	data, err := bus.HandleContext(ctx, &testCommand1{})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if data != "foo" {
+		t.Error(unexpectedDataError)
+	}
+	if data, _ = bus.Handle(&testCommand1{}); data != nil {
+		t.Error(unexpectedDataError)
+	}
+}
+
+func TestBus_HandleContextMiddleware(t *testing.T) {
+	bus := NewBus()
+	hdl := &testContextHandler{TestCommand1}
+	bus.SetMiddlewares(&testMiddleware{}, &testContextMiddleware{"bar"}, &testMiddleware{})
+
+	if err := bus.Initialize(hdl); err != nil {
+		t.Fatal(err.Error())
+	}
+	ctx := context.WithValue(context.Background(), testContextValueKey, "foo")
+	data, err := bus.HandleContext(ctx, &testCommand1{})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if data != "bar" {
+		t.Error(unexpectedDataError)
+	}
+}
+
+func TestBus_HandleAsyncContext(t *testing.T) {
+	bus := NewBus()
+	bus.SetWorkerPoolSize(4)
+	hdl := &testContextHandler{TestCommand1}
+
+	if err := bus.Initialize(hdl); err != nil {
+		t.Fatal(err.Error())
+	}
+	timeout := setupHandleTimeout(t)
+	ctx := context.WithValue(context.Background(), testContextValueKey, "foo")
+	as, err := bus.HandleAsyncContext(ctx, &testCommand1{})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if data, err := as.Await(); err != nil || data != "foo" {
+		t.Error(unexpectedDataError)
+	}
+
+	asl, err := bus.HandleAsyncListContext(ctx, &testCommand1{}, &testCommand1{})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	data, err := asl.Await()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if data[0] != "foo" || data[1] != "foo" {
+		t.Error(unexpectedDataError)
+	}
+
+	canceledCtx, cancel := context.WithCancel(context.Background())
+	cancel()
+	as, err = bus.HandleAsyncContext(canceledCtx, &testCommand1{})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if _, err = as.Await(); err != context.Canceled {
+		t.Error("Expected context.Canceled error.")
+	}
+	timeout.Stop()
+}
+
+func TestBus_ScheduleContext(t *testing.T) {
+	bus := NewBus()
+	hdl := &testContextHandler{TestCommand1}
+
+	if err := bus.Initialize(hdl); err != nil {
+		t.Fatal(err.Error())
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	if _, err := bus.ScheduleContext(ctx, &testCommand1{}, schedule.As(schedule.Cron().EveryDay())); err != nil {
+		t.Fatal(err.Error())
+	}
+	cancel()
+
+	timeout := setupHandleTimeout(t)
+	for {
+		bus.scheduleProcessor.Lock()
+		scheduled := len(bus.scheduleProcessor.scheduledCommands)
+		bus.scheduleProcessor.Unlock()
+		if scheduled == 0 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	timeout.Stop()
+}
+
 func TestBus_HandleAsync(t *testing.T) {
 	bus := NewBus()
 	bus.SetWorkerPoolSize(4)
@@ -168,59 +586,615 @@ func TestBus_HandleAsyncList(t *testing.T) {
 	timeout.Stop()
 }
 
-func TestBus_HandleClosure(t *testing.T) {
+func TestBus_HandleAsyncAwaitTimeout(t *testing.T) {
 	bus := NewBus()
+	bus.SetWorkerPoolSize(1)
+	hdl := newTestBlockingHandler(TestCommand1)
 
-	if err := bus.Initialize(); err != nil {
+	if err := bus.Initialize(hdl); err != nil {
 		t.Fatal(err.Error())
 	}
-	as, _ := bus.HandleAsync(Closure(func() (data any, err error) {
-		return "foo bar", nil
-	}))
-
-	data, err := as.Await()
+	timeout := setupHandleTimeout(t)
+	as, err := bus.HandleAsync(&testCommand1{})
 	if err != nil {
 		t.Fatal(err.Error())
 	}
-	data, ok := data.(string)
-	if !ok || data != "foo bar" {
-		t.Error(unexpectedDataError)
+	<-hdl.started
+	if _, err = as.AwaitTimeout(time.Millisecond); err != AwaitTimeoutError {
+		t.Error("Expected AwaitTimeoutError error.")
 	}
-}
-
-func TestBus_HandleClosureCustomHandler(t *testing.T) {
-	bus := NewBus()
-
-	hdl := &testClosureHandler{}
-	if err := bus.Initialize(hdl); err != nil {
-		t.Fatal(err.Error())
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err = as.AwaitContext(ctx); err != AwaitCanceledError {
+		t.Error("Expected AwaitCanceledError error.")
+	}
+	if as.Cancel() {
+		t.Error("The command should not be canceled while being processed.")
 	}
-	as, _ := bus.HandleAsync(Closure(func() (data any, err error) {
-		return 2, nil
-	}))
 
-	data, err := as.Await()
+	queued, err := bus.HandleAsync(&testCommand1{})
 	if err != nil {
 		t.Fatal(err.Error())
 	}
-	data, ok := data.(int)
-	if !ok || data != 4 {
-		t.Error(unexpectedDataError)
+	if !queued.Cancel() {
+		t.Error("The queued command should be canceled.")
 	}
-}
-
-func TestBus_HandleAsyncAwaitError(t *testing.T) {
-	bus := NewBus()
-	bus.SetWorkerPoolSize(4)
-	hdl := &testErrorHandler{}
-
-	if err := bus.Initialize(hdl); err != nil {
+	close(hdl.release)
+	if _, err = as.AwaitTimeout(time.Second); err != nil {
 		t.Fatal(err.Error())
 	}
-	cmd := &testCommandError{}
-	as, err := bus.HandleAsync(cmd)
-	if err != nil {
-		t.Fatal(err.Error())
+	if _, err = queued.AwaitContext(context.Background()); err != CommandCanceledError {
+		t.Error("Expected CommandCanceledError error.")
+	}
+
+	// ensure the canceled command was skipped by the worker
+	if _, err = bus.HandleAsync(&testCommand1{}); err != nil {
+		t.Fatal(err.Error())
+	}
+	for !hdl.handled.is(2) {
+		time.Sleep(time.Millisecond)
+	}
+	if len(hdl.started) != 1 {
+		t.Error("The canceled command should not be handled.")
+	}
+	timeout.Stop()
+}
+
+func TestBus_HandleTyped(t *testing.T) {
+	bus := NewBus()
+	err := Register(bus, TestLiteralCommand, func(ctx context.Context, cmd testCommand3) (string, error) {
+		return string(cmd) + " handled", nil
+	})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if err = Register(bus, TestLiteralCommand, func(ctx context.Context, cmd testCommand3) (int, error) {
+		return 0, nil
+	}); err != OneHandlerPerCommandError {
+		t.Error("Expected OneHandlerPerCommandError error.")
+	}
+	hdl := NewTypedHandler(TestCommand1, func(ctx context.Context, cmd *testCommand1) (*testCommand1, error) {
+		return cmd, nil
+	})
+	if err = bus.Initialize(hdl); err != nil {
+		t.Fatal(err.Error())
+	}
+	if err = Register(bus, TestCommand2, func(ctx context.Context, cmd *testCommand2) (any, error) {
+		return nil, nil
+	}); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	data, err := Dispatch[string](bus, testCommand3("foo"))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if data != "foo handled" {
+		t.Error(unexpectedDataError)
+	}
+	cmd := &testCommand1{}
+	cmdData, err := Dispatch[*testCommand1](bus, cmd)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if cmdData != cmd {
+		t.Error(unexpectedDataError)
+	}
+
+	var resErr *ResultTypeError
+	if _, err = Dispatch[int](bus, testCommand3("foo")); !errors.As(err, &resErr) {
+		t.Fatal("Expected ResultTypeError error.")
+	}
+	if resErr.Error() != "command: unexpected result type string for TestLiteralCommand, expected int" {
+		t.Error("Unexpected ResultTypeError message.")
+	}
+	var cmdErr *CommandTypeError
+	if _, err = hdl.Handle(testCommand3("foo")); !errors.As(err, &cmdErr) {
+		t.Error("Expected CommandTypeError error.")
+	}
+
+	timeout := setupHandleTimeout(t)
+	as, err := DispatchAsync[string](bus, testCommand3("bar"))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if data, err = as.Await(); err != nil || data != "bar handled" {
+		t.Error(unexpectedDataError)
+	}
+	if _, err = DispatchAsync[string](bus, &testCommandSlow{}); err != HandlerNotFoundError {
+		t.Error("Expected HandlerNotFoundError error.")
+	}
+	timeout.Stop()
+}
+
+func TestBus_HandleEnvelope(t *testing.T) {
+	bus := NewBus()
+	errHdl := &storeEnvelopeErrorsHandler{
+		storeErrorsHandler: storeErrorsHandler{errs: make(map[Identifier]error)},
+		envelopes:          make(chan *Envelope, 1),
+	}
+	bus.SetErrorHandlers(errHdl)
+	hdl1 := &testEnvelopeHandler{bus: bus, handles: TestCommand1, envelopes: make(chan *Envelope, 10)}
+	hdl2 := &testEnvelopeHandler{bus: bus, handles: TestCommand2, envelopes: make(chan *Envelope, 10)}
+	if err := bus.Initialize(hdl1, hdl2, &testErrorHandler{}); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	issued := NewEnvelope(&testCommand2{}).WithHeader("issuer", "test")
+	data, err := bus.Handle(issued)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	env := data.(*Envelope)
+	if env != issued || env.Header("issuer") != "test" || env.CorrelationID != env.ID {
+		t.Error("Unexpected envelope.")
+	}
+	if _, ok := (<-hdl2.envelopes).Command.(*testCommand2); !ok {
+		t.Error("Expected the handler to receive the original command.")
+	}
+
+	data, err = bus.Handle(&testCommand1{})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	parent, child := <-hdl1.envelopes, data.(*Envelope)
+	<-hdl2.envelopes
+	if child.CorrelationID != parent.ID || child.CausationID != parent.ID || child.ID == parent.ID {
+		t.Error("Expected the correlation to be propagated to the follow-up command.")
+	}
+
+	as, err := bus.HandleAsync(&testCommand2{})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if data, err = as.Await(); err != nil || data != as.Envelope() {
+		t.Error("Expected the envelope of the async command.")
+	}
+	<-hdl2.envelopes
+
+	if _, err = bus.Handle(NewEnvelope(&testCommandError{}).WithHeader("issuer", "test")); err == nil {
+		t.Error("Expected the command to fail.")
+	}
+	if env = <-errHdl.envelopes; env.Header("issuer") != "test" || errHdl.Error(&testCommandError{}) == nil {
+		t.Error("Expected the error handler to receive the envelope.")
+	}
+
+	timeout := setupHandleTimeout(t)
+	if _, err = bus.Schedule(issued, schedule.At(time.Now())); err != nil {
+		t.Fatal(err.Error())
+	}
+	if env = <-hdl2.envelopes; env.ID == issued.ID || env.Header("issuer") != "test" || env.CorrelationID != env.ID {
+		t.Error("Expected scheduled envelopes to be renewed for every execution.")
+	}
+	timeout.Stop()
+}
+
+func TestBus_CommandError(t *testing.T) {
+	bus := NewBus()
+	errHdl := &chanErrorsHandler{errs: make(chan error, 10)}
+	bus.SetErrorHandlers(errHdl)
+	if err := bus.Initialize(&testErrorHandler{}); err != nil {
+		t.Fatal(err.Error())
+	}
+	var cmdErr *CommandError
+	nextError := func() *CommandError {
+		if !errors.As(<-errHdl.errs, &cmdErr) {
+			t.Fatal("Expected CommandError error.")
+		}
+		return cmdErr
+	}
+
+	if _, err := bus.Handle(&testCommandError{}); err == nil || err.Error() != commandFailedError {
+		t.Error("Expected the original error to be returned.")
+	}
+	cmdErr = nextError()
+	if cmdErr.Identifier != TestErrorCommand || cmdErr.Stage != StageHandler || cmdErr.MiddlewareIndex != -1 ||
+		cmdErr.Async || cmdErr.Attempt != 1 || cmdErr.Err.Error() != commandFailedError {
+		t.Error("Unexpected CommandError.")
+	}
+	if cmdErr.Error() != "command: TestErrorCommand failed at handler: command failed" {
+		t.Error("Unexpected CommandError message.")
+	}
+
+	if _, err := bus.Handle(&testCommand1{}); err != HandlerNotFoundError {
+		t.Error("Expected HandlerNotFoundError error.")
+	}
+	if cmdErr = nextError(); cmdErr.Stage != StageLookup || !errors.Is(cmdErr, HandlerNotFoundError) {
+		t.Error("Expected the lookup CommandError to match HandlerNotFoundError.")
+	}
+
+	as, _ := bus.HandleAsync(&testCommandError{})
+	_, _ = as.Await()
+	if cmdErr = nextError(); !cmdErr.Async || cmdErr.ScheduleKey != uuid.Nil || cmdErr.Stage != StageHandler {
+		t.Error("Unexpected async CommandError.")
+	}
+
+	timeout := setupHandleTimeout(t)
+	key, err := bus.Schedule(&testCommandError{}, schedule.At(time.Now()))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if cmdErr = nextError(); !cmdErr.Async || cmdErr.ScheduleKey != *key {
+		t.Error("Unexpected scheduled CommandError.")
+	}
+	timeout.Stop()
+
+	bus = NewBus()
+	bus.SetErrorHandlers(errHdl)
+	bus.SetMiddlewares(&testMiddleware{}, &testErrorMiddleware{inwardFailure: true})
+	if err = bus.Initialize(&testErrorHandler{}); err != nil {
+		t.Fatal(err.Error())
+	}
+	if _, err = bus.Handle(&testCommandError{}); err == nil || err.Error() != middlewareInwardError {
+		t.Error("Expected middlewareInwardError error.")
+	}
+	if cmdErr = nextError(); cmdErr.Stage != StageMiddleware || cmdErr.MiddlewareIndex != 1 ||
+		cmdErr.Middleware != "*command.testErrorMiddleware" {
+		t.Error("Unexpected middleware CommandError.")
+	}
+}
+
+func TestBus_HandleTimeout(t *testing.T) {
+	timeout := setupHandleTimeout(t)
+	bus := NewBus()
+	bus.SetWorkerPoolSize(1)
+	bus.SetDefaultTimeout(10 * time.Millisecond)
+	bus.SetTimeout(time.Hour, TestCommand2)
+	errHdl := &chanErrorsHandler{errs: make(chan error, 10)}
+	bus.SetErrorHandlers(errHdl)
+	hdl := &testRunawayHandler{handles: TestCommand1, canceled: make(chan error, 10)}
+	if err := bus.Initialize(hdl, &testAsyncAwaitHandler{TestCommand2}); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	if _, err := bus.Handle(&testCommand1{}); err != TimeoutError {
+		t.Error("Expected TimeoutError error.")
+	} else if err.Error() != "command: the command timed out" {
+		t.Error("Unexpected TimeoutError message.")
+	}
+	if err := <-hdl.canceled; err != context.DeadlineExceeded {
+		t.Error("Expected the context of the handler to be canceled.")
+	}
+	var cmdErr *CommandError
+	if !errors.As(<-errHdl.errs, &cmdErr) || cmdErr.Stage != StageTimeout || !errors.Is(cmdErr, TimeoutError) {
+		t.Error("Expected the timeout to be reported.")
+	}
+
+	// the single worker is released after every timeout
+	asl, err := bus.HandleAsyncList(&testCommand1{}, &testCommand1{}, &testTimeoutCommand{timeout: time.Millisecond})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	results, _ := asl.AwaitIterator()
+	for res := range results {
+		if res.Err != TimeoutError {
+			t.Error("Expected TimeoutError error.")
+		}
+	}
+	if data, err := bus.Handle(&testCommand2{}); err != nil || data != "ok" {
+		t.Error(unexpectedDataError)
+	}
+
+	// follow-up commands are not canceled once the command that issued them completes
+	bus = NewBus()
+	bus.SetWorkerPoolSize(1)
+	bus.SetDefaultTimeout(time.Minute)
+	if err := bus.Initialize(&testFollowUpHandler{bus: bus, handles: TestCommand1, followUp: &testCommand2{}}, &testHandler{TestCommand2}); err != nil {
+		t.Fatal(err.Error())
+	}
+	as, err := bus.HandleAsync(&testCommand1{})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	followUp, err := as.Await()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if _, err = followUp.(*Async).Await(); err != nil {
+		t.Error("Expected the follow-up command to be processed.")
+	}
+	timeout.Stop()
+}
+
+func TestBus_DeadLetter(t *testing.T) {
+	bus := NewBus()
+	hdl := newTestFlakyHandler(TestLiteralCommand, 2)
+	store := NewMemoryDeadLetterStore()
+	bus.SetDeadLetterStore(store)
+	errHdl := &storeErrorsHandler{errs: make(map[Identifier]error)}
+	bus.SetErrorHandlers(errHdl)
+
+	if _, err := NewBus().Redrive(nil); err != DeadLetterStoreNotSetError {
+		t.Error("Expected DeadLetterStoreNotSetError error.")
+	}
+	if err := bus.Initialize(hdl); err != nil {
+		t.Fatal(err.Error())
+	}
+	timeout := setupHandleTimeout(t)
+	if _, err := bus.Handle(testCommand3("sync")); err == nil {
+		t.Error("Command handler was expected to throw an error.")
+	}
+	as, err := bus.HandleAsync(testCommand3("async"))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if _, err = as.Await(); err == nil {
+		t.Error("Command handler was expected to throw an error.")
+	}
+	deadLetters, _ := store.Load()
+	if len(deadLetters) != 1 {
+		t.Fatal("Only the failed async command should be dead lettered.")
+	}
+	dl := deadLetters[0]
+	if dl.Command != testCommand3("async") || dl.Identifier != TestLiteralCommand || dl.Attempts != 1 || dl.Err.Error() != commandFailedError {
+		t.Error("Unexpected dead letter.")
+	}
+	if dl.IssuedAt.IsZero() || dl.FailedAt.Before(dl.IssuedAt) {
+		t.Error("Unexpected dead letter timestamps.")
+	}
+
+	asl, err := bus.Redrive(func(dl *DeadLetter) bool {
+		return dl.Identifier == TestLiteralCommand
+	})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	data, err := asl.Await()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if data[0] != "ok" {
+		t.Error(unexpectedDataError)
+	}
+	if deadLetters, _ = store.Load(); len(deadLetters) != 0 {
+		t.Error("The redriven command should be removed from the dead letter store.")
+	}
+
+	// dead letters whose handler is no longer registered are reported and remain in the store
+	unhandled := &DeadLetter{ID: uuid.New(), Identifier: TestCommand1, Command: &testCommand1{}, Attempts: 1}
+	_ = store.Store(unhandled)
+	_ = store.Store(&DeadLetter{ID: uuid.New(), Identifier: TestLiteralCommand, Command: testCommand3("again"), Attempts: 1})
+	if asl, err = bus.Redrive(nil); err != nil {
+		t.Fatal(err.Error())
+	}
+	if data, err = asl.Await(); err != nil || len(data) != 1 || data[0] != "ok" {
+		t.Error("Expected the remaining dead letters to be redriven.")
+	}
+	if !errors.Is(errHdl.Error(&testCommand1{}), HandlerNotFoundError) {
+		t.Error("Expected HandlerNotFoundError error.")
+	}
+	if deadLetters, _ = store.Load(); len(deadLetters) != 1 || deadLetters[0].ID != unhandled.ID {
+		t.Error("Expected the dead letter that can not be redriven to remain in the store.")
+	}
+	timeout.Stop()
+}
+
+func TestBus_FileDeadLetterStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "dead_letters.json")
+	codec := NewJSONCodec()
+	codec.Register(testCommand3(""))
+	store, err := NewFileDeadLetterStore(path, codec)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	bus := NewBus()
+	bus.SetDeadLetterStore(store)
+	hdl := newTestFlakyHandler(TestLiteralCommand, 2)
+	if err = bus.Initialize(hdl); err != nil {
+		t.Fatal(err.Error())
+	}
+	timeout := setupHandleTimeout(t)
+	as, _ := bus.HandleAsync(testCommand3("foo"))
+	_, _ = as.Await()
+	asl, err := bus.Redrive(nil)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	_, _ = asl.Await()
+
+	reloaded, err := NewFileDeadLetterStore(path, codec)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	deadLetters, _ := reloaded.Load()
+	if len(deadLetters) != 1 {
+		t.Fatal("Expected the dead letter to be persisted.")
+	}
+	dl := deadLetters[0]
+	if dl.Command != testCommand3("foo") || dl.Attempts != 2 || dl.Err.Error() != commandFailedError {
+		t.Error("Unexpected dead letter.")
+	}
+
+	if _, err = NewFileDeadLetterStore(path, NewJSONCodec()); err != CommandNotRegisteredError {
+		t.Error("Expected CommandNotRegisteredError error.")
+	}
+	timeout.Stop()
+}
+
+func TestBus_HandlePanic(t *testing.T) {
+	bus := NewBus()
+	bus.SetWorkerPoolSize(1)
+	hdl := &testPanicHandler{TestCommand1}
+	hdl2 := &testHandler{TestCommand2}
+	errHdl := &storeErrorsHandler{
+		errs: make(map[Identifier]error),
+	}
+	bus.SetErrorHandlers(errHdl)
+
+	if err := bus.Initialize(hdl, hdl2); err != nil {
+		t.Fatal(err.Error())
+	}
+	var panicErr *PanicError
+	if _, err := bus.Handle(&testCommand1{}); !errors.As(err, &panicErr) {
+		t.Fatal("Expected PanicError error.")
+	}
+	if panicErr.Value != commandFailedError || len(panicErr.Stack) == 0 {
+		t.Error("Unexpected PanicError contents.")
+	}
+	if !errors.As(errHdl.Error(&testCommand1{}), &panicErr) {
+		t.Error("Expected the PanicError to be passed on to the error handlers.")
+	}
+
+	timeout := setupHandleTimeout(t)
+	as, err := bus.HandleAsync(&testCommand1{})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if _, err = as.Await(); !errors.As(err, &panicErr) {
+		t.Error("Expected PanicError error.")
+	}
+	// the single worker must still be alive
+	as, err = bus.HandleAsync(&testCommand2{})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if _, err = as.Await(); err != nil {
+		t.Fatal(err.Error())
+	}
+	timeout.Stop()
+}
+
+func TestBus_HandlePanicRecoveryDisabled(t *testing.T) {
+	bus := NewBus()
+	bus.SetPanicRecovery(false)
+	hdl := &testPanicHandler{TestCommand1}
+
+	if err := bus.Initialize(hdl); err != nil {
+		t.Fatal(err.Error())
+	}
+	defer func() {
+		if r := recover(); r != commandFailedError {
+			t.Error("Expected the panic to be propagated.")
+		}
+	}()
+	_, _ = bus.Handle(&testCommand1{})
+}
+
+func TestBus_Register(t *testing.T) {
+	bus := NewBus()
+	bus.SetWorkerPoolSize(1)
+	hdl := newTestBlockingHandler(TestCommand1)
+
+	if err := bus.Register(hdl); err != nil {
+		t.Fatal(err.Error())
+	}
+	if err := bus.Initialize(); err != nil {
+		t.Fatal(err.Error())
+	}
+	if err := bus.Register(&testHandler{TestCommand2}, &testHandler{TestCommand1}); err != OneHandlerPerCommandError {
+		t.Error("Expected OneHandlerPerCommandError error.")
+	}
+	if _, err := bus.Handle(&testCommand2{}); err != HandlerNotFoundError {
+		t.Error("Expected no handler to be registered on conflicts.")
+	}
+	if err := bus.Register(&testAsyncAwaitHandler{TestCommand2}); err != nil {
+		t.Fatal(err.Error())
+	}
+	if data, err := bus.Handle(&testCommand2{}); err != nil || data != "ok" {
+		t.Error(unexpectedDataError)
+	}
+
+	timeout := setupHandleTimeout(t)
+	inFlight, _ := bus.HandleAsync(&testCommand1{})
+	<-hdl.started
+	replaced, _ := bus.HandleAsync(&testCommand1{})
+	bus.Replace(&testAsyncAwaitHandler{TestCommand1})
+	close(hdl.release)
+	if data, err := inFlight.Await(); err != nil || data != nil {
+		t.Error("The in-flight command should complete with the previous handler.")
+	}
+	if data, err := replaced.Await(); err != nil || data != nil || !hdl.handled.is(1) {
+		t.Error("The queued command should be processed by the new handler.")
+	}
+
+	bus.Unregister(TestCommand1, TestCommand2)
+	if _, err := bus.Handle(&testCommand1{}); err != HandlerNotFoundError {
+		t.Error("Expected HandlerNotFoundError error.")
+	}
+	timeout.Stop()
+}
+
+func TestBus_RegisterConcurrently(t *testing.T) {
+	bus := NewBus()
+	if err := bus.Initialize(&testHandler{TestCommand1}); err != nil {
+		t.Fatal(err.Error())
+	}
+	wg := &sync.WaitGroup{}
+	for i := 0; i < 10; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			bus.Replace(&testHandler{TestCommand2})
+			bus.Unregister(TestCommand2)
+			_ = bus.Register(&testHandler{TestCommand2})
+		}()
+		go func() {
+			defer wg.Done()
+			_, _ = bus.Handle(&testCommand2{})
+			as, err := bus.HandleAsync(&testCommand1{})
+			if err != nil {
+				t.Error(err.Error())
+				return
+			}
+			_, _ = as.Await()
+		}()
+	}
+	wg.Wait()
+}
+
+func TestBus_HandleClosure(t *testing.T) {
+	bus := NewBus()
+
+	if err := bus.Initialize(); err != nil {
+		t.Fatal(err.Error())
+	}
+	as, _ := bus.HandleAsync(Closure(func() (data any, err error) {
+		return "foo bar", nil
+	}))
+
+	data, err := as.Await()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	data, ok := data.(string)
+	if !ok || data != "foo bar" {
+		t.Error(unexpectedDataError)
+	}
+}
+
+func TestBus_HandleClosureCustomHandler(t *testing.T) {
+	bus := NewBus()
+
+	hdl := &testClosureHandler{}
+	if err := bus.Initialize(hdl); err != nil {
+		t.Fatal(err.Error())
+	}
+	as, _ := bus.HandleAsync(Closure(func() (data any, err error) {
+		return 2, nil
+	}))
+
+	data, err := as.Await()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	data, ok := data.(int)
+	if !ok || data != 4 {
+		t.Error(unexpectedDataError)
+	}
+}
+
+func TestBus_HandleAsyncAwaitError(t *testing.T) {
+	bus := NewBus()
+	bus.SetWorkerPoolSize(4)
+	hdl := &testErrorHandler{}
+
+	if err := bus.Initialize(hdl); err != nil {
+		t.Fatal(err.Error())
+	}
+	cmd := &testCommandError{}
+	as, err := bus.HandleAsync(cmd)
+	if err != nil {
+		t.Fatal(err.Error())
 	}
 
 	timeout := setupHandleTimeout(t)
@@ -240,83 +1214,481 @@ func TestBus_HandleAsyncListAwaitError(t *testing.T) {
 	bus.SetWorkerPoolSize(4)
 	hdl := &testErrorHandler{}
 
-	if err := bus.Initialize(hdl); err != nil {
+	if err := bus.Initialize(hdl); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	asl := NewAsyncList()
+	_, err := asl.Await()
+	if err == nil {
+		t.Error("Async list was expected to throw an error.")
+	}
+	if err != EmptyAwaitListError {
+		t.Fatal("Expected EmptyAwaitListError error.")
+	}
+	cmd := &testCommandError{}
+	as, err := bus.HandleAsync(cmd)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	asl.Push(as)
+	_, err = asl.Await()
+	if err == nil {
+		t.Error("Command handler was expected to throw an error.")
+	}
+	if err.Error() != commandFailedError {
+		t.Fatal(err.Error())
+	}
+}
+
+func TestBus_HandleClosureError(t *testing.T) {
+	bus := NewBus()
+
+	if err := bus.Initialize(); err != nil {
+		t.Fatal(err.Error())
+	}
+	if _, err := bus.Handle(&testFakeClosureCommand{}); err == nil || err != InvalidClosureCommandError {
+		t.Fatal("Expected InvalidClosureCommandError error.")
+	}
+}
+
+func TestBus_HandleScheduled(t *testing.T) {
+	bus := NewBus()
+	bus.SetWorkerPoolSize(4)
+	wg := &sync.WaitGroup{}
+	hdl := &testAsyncHandler{wg: wg, handles: TestCommand1}
+
+	_, err := bus.Schedule(&testCommand1{}, schedule.At(time.Now()))
+	if err == nil || err != BusNotInitializedError {
+		t.Error("Expected BusNotInitializedError error.")
+	} else if err.Error() != "command: the bus is not initialized" {
+		t.Error("Unexpected BusNotInitializedError message.")
+	}
+	if err := bus.Initialize(hdl); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	wg.Add(1)
+	if _, err = bus.Schedule(&testCommand1{}, schedule.At(time.Now())); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	wg.Add(100)
+	sch := schedule.At(time.Now())
+	sch.AddCron(schedule.Cron().OnMilliseconds(schedule.Between(0, 998).Every(2)))
+	uuid1, _ := bus.Schedule(&testCommand1{}, sch)
+
+	for i := 0; i < 100; i++ {
+		bus.scheduleProcessor.trigger()
+	}
+
+	timeout := setupHandleTimeout(t)
+	wg.Wait()
+	bus.RemoveScheduled(*uuid1)
+	if len(bus.scheduleProcessor.scheduledCommands) > 0 {
+		t.Error("The scheduled commands should be empty.")
+	}
+	timeout.Stop()
+}
+
+func TestBus_Scheduled(t *testing.T) {
+	bus := NewBus()
+	clock := clocktest.NewFakeClock(time.Now())
+	bus.SetClock(clock)
+	if err := bus.Initialize(&testErrorHandler{}, &testHandler{TestCommand1}); err != nil {
+		t.Fatal(err.Error())
+	}
+	timeout := setupHandleTimeout(t)
+	now := clock.Now()
+	failing, _ := bus.Schedule(&testCommandError{}, schedule.At(now, now.Add(time.Hour)))
+	paused, _ := bus.Schedule(&testCommand1{}, schedule.At(now.Add(time.Minute), now.Add(time.Hour)))
+	bus.PauseScheduled(*paused)
+
+	var entry *ScheduledEntry
+	for entry == nil || entry.LastErr == nil {
+		time.Sleep(time.Millisecond)
+		entry, _ = bus.InspectScheduled(*failing)
+	}
+	if entry.Identifier != TestErrorCommand || entry.Runs != 1 || entry.LastErr.Error() != commandFailedError ||
+		!entry.NextRun.Equal(now.Add(time.Hour)) || entry.LastRunAt.IsZero() {
+		t.Error("Unexpected scheduled entry.")
+	}
+	entries := bus.Scheduled()
+	if len(entries) != 2 || entries[0].Key != *failing || entries[1].Key != *paused || !entries[1].Paused {
+		t.Error("Unexpected scheduled entries.")
+	}
+
+	// the occurrences due while paused are skipped
+	clock.Advance(2 * time.Minute)
+	bus.ResumeScheduled(*paused)
+	entry, _ = bus.InspectScheduled(*paused)
+	if entry.Paused || entry.Runs != 0 || !entry.NextRun.Equal(now.Add(time.Hour)) {
+		t.Error("Expected the missed occurrences to be skipped.")
+	}
+
+	if err := bus.RescheduleScheduled(*paused, schedule.At(clock.Now().Add(time.Minute), now.Add(time.Hour))); err != nil {
+		t.Fatal(err.Error())
+	}
+	if entry, _ = bus.InspectScheduled(*paused); entry.Runs != 0 {
+		t.Error("Expected the command not to be issued before the clock advances.")
+	}
+	clock.Advance(time.Minute)
+	for entry.Runs != 1 {
+		time.Sleep(time.Millisecond)
+		entry, _ = bus.InspectScheduled(*paused)
+	}
+
+	if _, err := bus.InspectScheduled(uuid.New()); err != ScheduledCommandNotFoundError {
+		t.Error("Expected ScheduledCommandNotFoundError error.")
+	} else if err.Error() != "command: the scheduled command was not found" {
+		t.Error("Unexpected ScheduledCommandNotFoundError message.")
+	}
+	if err := bus.RescheduleScheduled(uuid.New(), schedule.In(time.Hour)); err != ScheduledCommandNotFoundError {
+		t.Error("Expected ScheduledCommandNotFoundError error.")
+	}
+	timeout.Stop()
+}
+
+func TestBus_ScheduleStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "schedules.json")
+	codec := NewJSONCodec()
+	codec.Register(&testPersistentCommand{})
+	store, err := NewFileScheduleStore(path, codec)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	bus := NewBus()
+	bus.SetScheduleStore(store)
+	if err = bus.Initialize(&testHandler{TestCommand1}, &testHandler{TestCommand2}); err != nil {
+		t.Fatal(err.Error())
+	}
+	timeout := setupHandleTimeout(t)
+	now := time.Now()
+	cmd := &testPersistentCommand{At: now}
+	key, _ := bus.Schedule(cmd, cmd.Schedule())
+	cmd = &testPersistentCommand{At: now.Add(time.Minute)}
+	paused, _ := bus.Schedule(cmd, cmd.Schedule())
+	bus.PauseScheduled(*paused)
+	_, _ = bus.Schedule(&testCommand1{}, schedule.At(now.Add(time.Hour)))
+	for entry, _ := bus.InspectScheduled(*key); entry.Runs != 1; entry, _ = bus.InspectScheduled(*key) {
+		time.Sleep(time.Millisecond)
+	}
+	if err = bus.Stop(context.Background()); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	reloaded, err := NewFileScheduleStore(path, codec)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	bus = NewBus()
+	bus.SetScheduleStore(reloaded)
+	if err = bus.Initialize(&testHandler{TestCommand1}, &testHandler{TestCommand2}); err != nil {
+		t.Fatal(err.Error())
+	}
+	entries := bus.Scheduled()
+	if len(entries) != 2 {
+		t.Fatal("Expected only the persistent commands to be reloaded.")
+	}
+	if entries[0].Key != *key || !entries[0].NextRun.Equal(now.Add(time.Hour)) || entries[0].Paused {
+		t.Error("Expected the schedule to resume from the persisted next run.")
+	}
+	if entries[1].Key != *paused || !entries[1].NextRun.Equal(now.Add(time.Minute)) || !entries[1].Paused {
+		t.Error("Expected the paused command to be reloaded.")
+	}
+	bus.RemoveScheduled(*key)
+	if records, _ := reloaded.Load(); len(records) != 1 || records[0].Key != *paused {
+		t.Error("Expected the removed command to be removed from the store.")
+	}
+
+	// records that can not be reloaded are reported and removed
+	invalid := NewMemoryScheduleStore()
+	_ = invalid.Store(&ScheduleRecord{Key: uuid.New(), Identifier: TestCommand1, Command: &testCommand1{}})
+	errHdl := &chanErrorsHandler{errs: make(chan error, 1)}
+	bus = NewBus()
+	bus.SetErrorHandlers(errHdl)
+	bus.SetScheduleStore(invalid)
+	if err = bus.Initialize(&testHandler{TestCommand1}); err != nil {
+		t.Fatal(err.Error())
+	}
+	if err = <-errHdl.errs; !errors.Is(err, InvalidCommandError) {
+		t.Error("Expected InvalidCommandError error.")
+	}
+	if records, _ := invalid.Load(); len(records) != 0 || len(bus.Scheduled()) != 0 {
+		t.Error("Expected the invalid record to be removed.")
+	}
+	timeout.Stop()
+}
+
+func TestBus_ScheduleStoreUndecodable(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "schedules.json")
+	codec := NewJSONCodec()
+	codec.Register(&testPersistentCommand{})
+	now := time.Now()
+	valid, _ := codec.Encode(&testPersistentCommand{At: now})
+	records := []scheduleRecord{
+		{Key: uuid.New(), Identifier: "Gone", Command: []byte(`{}`), NextRun: now.Add(time.Hour), CreatedAt: now},
+		{Key: uuid.New(), Identifier: "Gone", Command: []byte(`{}`), NextRun: now.Add(time.Hour), CreatedAt: now},
+		{Key: uuid.New(), Identifier: TestCommand2, Command: valid, NextRun: now.Add(time.Hour), CreatedAt: now},
+	}
+	data, _ := json.Marshal(records)
+	if err := writeFileAtomic(path, data); err != nil {
+		t.Fatal(err.Error())
+	}
+	store, err := NewFileScheduleStore(path, codec)
+	if err != nil {
+		t.Fatal("Expected the store to load despite the commands that can not be decoded.")
+	}
+
+	errHdl := &chanErrorsHandler{errs: make(chan error, 2)}
+	bus := NewBus()
+	bus.SetErrorHandlers(errHdl)
+	bus.SetScheduleStore(store)
+	if err = bus.Initialize(&testHandler{TestCommand2}); err != nil {
+		t.Fatal(err.Error())
+	}
+	for i := 0; i < 2; i++ {
+		var cmdErr *CommandError
+		if err = <-errHdl.errs; !errors.As(err, &cmdErr) || !errors.Is(err, CommandNotRegisteredError) || cmdErr.Identifier != "Gone" {
+			t.Error("Expected the commands that can not be decoded to be reported.")
+		}
+	}
+	if entries := bus.Scheduled(); len(entries) != 1 || entries[0].Key != records[2].Key {
+		t.Error("Expected the remaining commands to be reloaded.")
+	}
+
+	reloaded, err := NewFileScheduleStore(path, codec)
+	if err != nil {
 		t.Fatal(err.Error())
 	}
+	if loaded, _ := reloaded.Load(); len(loaded) != 1 || loaded[0].Key != records[2].Key {
+		t.Error("Expected the commands that can not be decoded to be removed from the file.")
+	}
+}
 
-	asl := NewAsyncList()
-	_, err := asl.Await()
-	if err == nil {
-		t.Error("Async list was expected to throw an error.")
+func TestBus_ScheduleStoreBlocking(t *testing.T) {
+	store := &testBlockingScheduleStore{
+		ScheduleStore: NewMemoryScheduleStore(),
+		writes:        newCounter(),
+		blocked:       make(chan bool, 1),
+		release:       make(chan bool),
 	}
-	if err != EmptyAwaitListError {
-		t.Fatal("Expected EmptyAwaitListError error.")
+	bus := NewBus()
+	bus.SetScheduleStore(store)
+	if err := bus.Initialize(&testHandler{TestCommand1}, &testHandler{TestCommand2}); err != nil {
+		t.Fatal(err.Error())
 	}
-	cmd := &testCommandError{}
-	as, err := bus.HandleAsync(cmd)
+	timeout := setupHandleTimeout(t)
+	cmd := &testPersistentCommand{At: time.Now()}
+	key, err := bus.Schedule(cmd, cmd.Schedule())
 	if err != nil {
 		t.Fatal(err.Error())
 	}
-	asl.Push(as)
-	_, err = asl.Await()
-	if err == nil {
-		t.Error("Command handler was expected to throw an error.")
+	// the store is written once the command is issued, without blocking the schedule meanwhile
+	<-store.blocked
+	if entry, err := bus.InspectScheduled(*key); err != nil || entry.Runs != 1 {
+		t.Error("Expected the scheduled command to be inspected while it is being persisted.")
 	}
-	if err.Error() != commandFailedError {
-		t.Fatal(err.Error())
+	other, _ := bus.Schedule(&testCommand1{}, schedule.At(time.Now(), time.Now().Add(time.Hour)))
+	for entry, _ := bus.InspectScheduled(*other); entry.Runs != 1; entry, _ = bus.InspectScheduled(*other) {
+		time.Sleep(time.Millisecond)
 	}
+	close(store.release)
+	timeout.Stop()
 }
 
-func TestBus_HandleClosureError(t *testing.T) {
+func TestBus_ScheduleMisfire(t *testing.T) {
 	bus := NewBus()
-
-	if err := bus.Initialize(); err != nil {
+	clock := clocktest.NewFakeClock(time.Now())
+	bus.SetClock(clock)
+	bus.SetMisfirePolicy(MisfirePolicy{Strategy: MisfireCatchUp})
+	if err := bus.Initialize(&testHandler{TestCommand1}); err != nil {
 		t.Fatal(err.Error())
 	}
-	if _, err := bus.Handle(&testFakeClosureCommand{}); err == nil || err != InvalidClosureCommandError {
-		t.Fatal("Expected InvalidClosureCommandError error.")
+	timeout := setupHandleTimeout(t)
+	now := clock.Now()
+	next := now.Add(time.Hour)
+	runs := map[Command]int{
+		&testCommand1{}: 3,
+		&testMisfireCommand{MisfirePolicy{Strategy: MisfireFireOnce}}:                      1,
+		&testMisfireCommand{MisfirePolicy{Strategy: MisfireSkip}}:                          0,
+		&testMisfireCommand{MisfirePolicy{Strategy: MisfireGrace, Grace: time.Minute}}:     1,
+		&testMisfireCommand{MisfirePolicy{Strategy: MisfireGrace, Grace: 5 * time.Second}}: 0,
+	}
+	keys := make(map[uuid.UUID]Command)
+	for cmd := range runs {
+		key, err := bus.Schedule(cmd, schedule.At(now.Add(-time.Hour), now.Add(-time.Minute), now.Add(-10*time.Second), next))
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		keys[*key] = cmd
+	}
+	for key, cmd := range keys {
+		entry, _ := bus.InspectScheduled(key)
+		for !entry.NextRun.Equal(next) {
+			time.Sleep(time.Millisecond)
+			entry, _ = bus.InspectScheduled(key)
+		}
+		if entry.Runs != runs[cmd] {
+			t.Errorf("Expected %d runs, got %d.", runs[cmd], entry.Runs)
+		}
 	}
+	timeout.Stop()
 }
 
-func TestBus_HandleScheduled(t *testing.T) {
+func TestMisfirePolicy_Fires(t *testing.T) {
+	policy := MisfirePolicy{Strategy: MisfireSkip}
+	if policy.fires(2, time.Millisecond) != 1 || policy.fires(0, 0) != 0 {
+		t.Error("Expected the occurrences on time to be issued.")
+	}
+	policy = MisfirePolicy{Strategy: MisfireGrace}
+	if policy.fires(1, misfireThreshold) != 1 || policy.fires(1, 2*misfireThreshold) != 0 {
+		t.Error("Expected the grace period to be at least the misfire threshold.")
+	}
+}
+
+func TestBus_ScheduleOverlap(t *testing.T) {
 	bus := NewBus()
 	bus.SetWorkerPoolSize(4)
-	wg := &sync.WaitGroup{}
-	hdl := &testAsyncHandler{wg: wg, handles: TestCommand1}
+	bus.SetOverlapPolicy(OverlapSkip)
+	hdl := newTestBlockingHandler(TestCommand1)
+	hdl2 := &testRunawayHandler{handles: TestCommand2, started: make(chan bool, 10), canceled: make(chan error, 10)}
+	if err := bus.Initialize(hdl, hdl2); err != nil {
+		t.Fatal(err.Error())
+	}
+	timeout := setupHandleTimeout(t)
+	awaitNextRun := func(key *uuid.UUID, next time.Time) *ScheduledEntry {
+		entry, _ := bus.InspectScheduled(*key)
+		for !entry.NextRun.Equal(next) {
+			time.Sleep(time.Millisecond)
+			entry, _ = bus.InspectScheduled(*key)
+		}
+		return entry
+	}
 
-	_, err := bus.Schedule(&testCommand1{}, schedule.At(time.Now()))
-	if err == nil || err != BusNotInitializedError {
-		t.Error("Expected BusNotInitializedError error.")
-	} else if err.Error() != "command: the bus is not initialized" {
-		t.Error("Unexpected BusNotInitializedError message.")
+	now := time.Now()
+	next := now.Add(time.Hour)
+	skipped, _ := bus.Schedule(&testCommand1{}, schedule.At(now, now.Add(10*time.Millisecond), now.Add(20*time.Millisecond), next))
+	<-hdl.started
+	if entry := awaitNextRun(skipped, next); entry.Runs != 1 || !entry.Running {
+		t.Error("Expected the overlapping runs to be skipped.")
 	}
-	if err := bus.Initialize(hdl); err != nil {
+	hdl.release <- true
+
+	now = time.Now()
+	next = now.Add(time.Hour)
+	queued, _ := bus.Schedule(&testOverlapCommand{TestCommand1, OverlapQueue}, schedule.At(now, now.Add(10*time.Millisecond), now.Add(20*time.Millisecond), next))
+	<-hdl.started
+	if entry := awaitNextRun(queued, next); entry.Runs != 1 {
+		t.Error("Expected the overlapping runs to be queued.")
+	}
+	hdl.release <- true
+	<-hdl.started
+	hdl.release <- true
+	for entry, _ := bus.InspectScheduled(*queued); entry.Running; entry, _ = bus.InspectScheduled(*queued) {
+		time.Sleep(time.Millisecond)
+	}
+	if entry, _ := bus.InspectScheduled(*queued); entry.Runs != 2 || !hdl.handled.is(3) {
+		t.Error("Expected a single queued run to be issued.")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	replaced, _ := bus.ScheduleContext(ctx, &testOverlapCommand{TestCommand2, OverlapReplace}, schedule.At(time.Now(), next))
+	<-hdl2.started
+	if err := bus.RescheduleScheduled(*replaced, schedule.At(time.Now(), next)); err != nil {
 		t.Fatal(err.Error())
 	}
+	if err := <-hdl2.canceled; err != context.Canceled {
+		t.Error("Expected the replaced run to be canceled.")
+	}
+	<-hdl2.started
+	if entry, _ := bus.InspectScheduled(*replaced); entry.Runs != 2 || !entry.Running {
+		t.Error("Expected the replacing run to be issued.")
+	}
+	cancel()
+	<-hdl2.canceled
+	timeout.Stop()
+}
 
-	wg.Add(1)
-	if _, err = bus.Schedule(&testCommand1{}, schedule.At(time.Now())); err != nil {
+func TestBus_Clock(t *testing.T) {
+	bus := NewBus()
+	bus.SetWorkerPoolSize(2)
+	clock := clocktest.NewFakeClock(time.Now())
+	bus.SetClock(clock)
+	bus.SetDefaultTimeout(time.Minute)
+	hdl := newTestBlockingHandler(TestCommand1)
+	hdl2 := &testRunawayHandler{handles: TestCommand2, started: make(chan bool, 1), canceled: make(chan error, 1)}
+	errHdl := &chanErrorsHandler{errs: make(chan error, 1)}
+	bus.SetErrorHandlers(errHdl)
+	if err := bus.Initialize(hdl, hdl2); err != nil {
 		t.Fatal(err.Error())
 	}
+	timeout := setupHandleTimeout(t)
+	if bus.Clock() != clock {
+		t.Error("Expected the fake clock to be used.")
+	}
 
-	wg.Add(100)
-	sch := schedule.At(time.Now())
-	sch.AddCron(schedule.Cron().OnMilliseconds(schedule.Between(0, 998).Every(2)))
-	uuid1, _ := bus.Schedule(&testCommand1{}, sch)
+	start := clock.Now()
+	key, _ := bus.Schedule(&testCommand1{}, schedule.At(start.Add(time.Hour), start.Add(2*time.Hour)))
+	clock.Advance(59 * time.Minute)
+	if entry, _ := bus.InspectScheduled(*key); entry.Runs != 0 {
+		t.Error("Expected the command not to be issued yet.")
+	}
+	clock.Advance(time.Minute)
+	<-hdl.started
+	hdl.release <- true
+	if entry, _ := bus.InspectScheduled(*key); entry.Runs != 1 || !entry.LastRunAt.Equal(start.Add(time.Hour)) {
+		t.Error("Expected the command to be issued according to the clock.")
+	}
 
-	for i := 0; i < 100; i++ {
-		bus.scheduleProcessor.trigger()
+	issuedAt := clock.Now()
+	as, _ := bus.HandleAsync(&testCommand{TestCommand2})
+	<-hdl2.started
+	clock.Advance(time.Minute)
+	if _, err := as.Await(); err != TimeoutError {
+		t.Error("Expected TimeoutError error.")
+	}
+	cmdErr, ok := (<-errHdl.errs).(*CommandError)
+	if !ok || cmdErr.Duration != time.Minute || !cmdErr.envelope.CreatedAt.Equal(issuedAt) {
+		t.Error("Expected the error to be timed according to the clock.")
 	}
+	timeout.Stop()
+}
 
+func TestBus_ScheduleFollowUp(t *testing.T) {
+	bus := NewBus()
+	bus.SetWorkerPoolSize(1)
+	if err := bus.Initialize(&testFollowUpHandler{bus: bus, handles: TestCommand1, followUp: &testCommand2{}}, &testHandler{TestCommand2}); err != nil {
+		t.Fatal(err.Error())
+	}
 	timeout := setupHandleTimeout(t)
-	wg.Wait()
-	bus.RemoveScheduled(*uuid1)
-	if len(bus.scheduleProcessor.scheduledCommands) > 0 {
-		t.Error("The scheduled commands should be empty.")
+	key, _ := bus.Schedule(&testCommand1{}, schedule.At(time.Now(), time.Now().Add(time.Hour)))
+	entry, _ := bus.InspectScheduled(*key)
+	for ; entry.LastResult == nil; entry, _ = bus.InspectScheduled(*key) {
+		time.Sleep(time.Millisecond)
+	}
+	// the follow-up is not canceled once the run that issued it completes
+	if _, err := entry.LastResult.(*Async).Await(); err != nil {
+		t.Error("Expected the follow-up command to be processed.")
 	}
 	timeout.Stop()
 }
 
+func TestMemoryScheduleStore(t *testing.T) {
+	store := NewMemoryScheduleStore()
+	key1, key2 := uuid.New(), uuid.New()
+	_ = store.Store(&ScheduleRecord{Key: key1})
+	_ = store.Store(&ScheduleRecord{Key: key2})
+	_ = store.Store(&ScheduleRecord{Key: key1, Paused: true})
+	if records, _ := store.Load(); len(records) != 2 || records[0].Key != key1 || !records[0].Paused {
+		t.Error("Expected the record to be replaced.")
+	}
+	_ = store.Remove(key1)
+	if records, _ := store.Load(); len(records) != 1 || records[0].Key != key2 {
+		t.Error("Expected the record to be removed.")
+	}
+}
+
 func TestBus_HandleMiddleware(t *testing.T) {
 	bus := NewBus()
 	hdl := &testHandler{TestCommand1}
@@ -448,7 +1820,7 @@ func evaluateMessages(t *testing.T, expectedList []string, messageChan <-chan st
 
 func TestBus_Shutdown(t *testing.T) {
 	bus := NewBus()
-	hdl := &testHandler{TestCommand1}
+	hdl := newTestBlockingHandler(TestCommand1)
 
 	wg := &sync.WaitGroup{}
 	wg.Add(1)
@@ -460,6 +1832,7 @@ func TestBus_Shutdown(t *testing.T) {
 	if _, err := bus.HandleAsync(&testCommand1{}); err != nil {
 		t.Fatal(err.Error())
 	}
+	<-hdl.started
 	bus.Shutdown()
 	for i := 0; i < 1000; i++ {
 		if _, err := bus.HandleAsync(&testCommand1{}); err == nil || err != BusIsShuttingDownError {
@@ -468,7 +1841,7 @@ func TestBus_Shutdown(t *testing.T) {
 	}
 	go func() {
 		// graceful shutdown
-		if !bus.shuttingDown.enabled() {
+		if bus.State() != StateStopping {
 			t.Error("The bus should be shutting down.")
 		}
 		_, err := bus.Handle(&testCommand1{})
@@ -479,11 +1852,412 @@ func TestBus_Shutdown(t *testing.T) {
 		}
 		wg.Done()
 	}()
+	wg.Wait()
+	// the in-flight command holds the bus in the stopping state until it is processed
+	hdl.release <- true
 
-	for bus.shuttingDown.enabled() {
+	for bus.State() == StateStopping {
 		time.Sleep(time.Microsecond)
 	}
+	if !hdl.handled.is(1) {
+		t.Error("Expected the in-flight command to be processed.")
+	}
+}
+
+func TestBus_ShutdownContext(t *testing.T) {
+	timeout := setupHandleTimeout(t)
+	bus := NewBus()
+	bus.SetWorkerPoolSize(1)
+	hdl := newTestBlockingHandler(TestCommand1)
+	if err := bus.Initialize(hdl); err != nil {
+		t.Fatal(err.Error())
+	}
+	asl := NewAsyncList()
+	for i := 0; i < 5; i++ {
+		as, err := bus.HandleAsync(&testCommand1{})
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		asl.Push(as)
+	}
+	<-hdl.started
+	go func() {
+		for i := 0; i < 5; i++ {
+			hdl.release <- true
+		}
+	}()
+	if err := bus.ShutdownContext(context.Background()); err != nil {
+		t.Fatal(err.Error())
+	}
+	if !hdl.handled.is(5) {
+		t.Error("Expected all the queued commands to be handled.")
+	}
+	if _, err := asl.Await(); err != nil {
+		t.Fatal(err.Error())
+	}
+	if bus.State() != StateStopped {
+		t.Error("Expected the bus to be fully stopped.")
+	}
+
+	// abandoning the queued commands
+	bus = NewBus()
+	bus.SetWorkerPoolSize(1)
+	store := NewMemoryDeadLetterStore()
+	bus.SetDeadLetterStore(store)
+	hdl = newTestBlockingHandler(TestCommand1)
+	if err := bus.Initialize(hdl); err != nil {
+		t.Fatal(err.Error())
+	}
+	processing, _ := bus.HandleAsync(&testCommand1{})
+	<-hdl.started
+	queued, _ := bus.HandleAsync(&testCommand1{})
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	err := bus.ShutdownContext(ctx)
+	var shutdownErr *ShutdownError
+	if !errors.As(err, &shutdownErr) || shutdownErr.Abandoned != 1 {
+		t.Fatal("Expected ShutdownError error with 1 abandoned command.")
+	}
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Error("Expected the ShutdownError to wrap the context error.")
+	}
+	if _, err = queued.Await(); err != BusIsShuttingDownError {
+		t.Error("Expected BusIsShuttingDownError error.")
+	}
+	if dls, _ := store.Load(); len(dls) != 1 {
+		t.Error("Expected the abandoned command to be dead lettered.")
+	}
+	if err = bus.ShutdownContext(context.Background()); err != BusIsShuttingDownError {
+		t.Error("Expected BusIsShuttingDownError error.")
+	}
+	close(hdl.release)
+	if _, err = processing.Await(); err != nil {
+		t.Fatal(err.Error())
+	}
+	timeout.Stop()
+}
+
+func TestBus_ShutdownConcurrently(t *testing.T) {
+	bus := NewBus()
+	bus.SetWorkerPoolSize(2)
+	if err := bus.Initialize(&testHandler{TestCommand1}); err != nil {
+		t.Fatal(err.Error())
+	}
+	timeout := setupHandleTimeout(t)
+
+	queued := make(chan *Async, 100000)
+	wg := &sync.WaitGroup{}
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				as, err := bus.HandleAsync(&testCommand1{})
+				if err != nil {
+					return
+				}
+				queued <- as
+			}
+		}()
+	}
+	time.Sleep(time.Millisecond)
+	if err := bus.ShutdownContext(context.Background()); err != nil {
+		t.Fatal(err.Error())
+	}
+	wg.Wait()
+	close(queued)
+	if err := bus.workerPool.enqueue(newAsync(context.Background(), NewEnvelope(&testCommand1{}), time.Now())); err != BusIsShuttingDownError {
+		t.Error("Expected BusIsShuttingDownError error.")
+	}
+	if !bus.workerPool.drained() {
+		t.Error("Expected the rejected command not to be pending.")
+	}
+	// every command accepted by the bus must be processed, even if queued while it was shutting down
+	for as := range queued {
+		if _, err := as.Await(); err != nil {
+			t.Fatal(err.Error())
+		}
+	}
+	timeout.Stop()
+}
+
+func TestBus_RestartConcurrently(t *testing.T) {
+	bus := NewBus()
+	bus.SetWorkerPoolSize(2)
+	bus.SetPool("dedicated", 1, 10, TestCommand2)
+	if err := bus.Initialize(&testHandler{TestCommand1}, &testHandler{TestCommand2}); err != nil {
+		t.Fatal(err.Error())
+	}
+	timeout := setupHandleTimeout(t)
+
+	stop := make(chan bool)
+	queued := make(chan *Async, 100000)
+	wg := &sync.WaitGroup{}
+	for _, cmd := range []Command{&testCommand1{}, &testCommand2{}} {
+		wg.Add(1)
+		go func(cmd Command) {
+			defer wg.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				_ = bus.WorkerPoolSize()
+				if as, err := bus.HandleAsync(cmd); err == nil {
+					queued <- as
+				}
+			}
+		}(cmd)
+	}
+	for i := 0; i < 20; i++ {
+		if err := bus.Restart(context.Background()); err != nil {
+			t.Fatal(err.Error())
+		}
+	}
+	close(stop)
 	wg.Wait()
+	close(queued)
+	// the commands accepted by any of the pools are processed, however many restarts happened meanwhile
+	for as := range queued {
+		if _, err := as.Await(); err != nil {
+			t.Fatal(err.Error())
+		}
+	}
+	timeout.Stop()
+}
+
+func TestBus_Lifecycle(t *testing.T) {
+	bus := NewBus()
+	hdl := &testAsyncAwaitHandler{TestCommand2}
+	ctx := context.Background()
+	if bus.State() != StateStopped {
+		t.Error("Expected the bus to be stopped.")
+	}
+	if err := bus.Stop(ctx); err != BusNotInitializedError {
+		t.Error("Expected BusNotInitializedError error.")
+	}
+
+	started, stopping, stopped := newCounter(), newCounter(), newCounter()
+	bus.OnStart(func(bus *Bus) error {
+		if bus.State() != StateStarting {
+			t.Error("Expected the bus to be starting.")
+		}
+		bus.SetWorkerPoolSize(int(started.increment()))
+		return nil
+	})
+	bus.OnStopping(func(bus *Bus) error {
+		if _, err := bus.Handle(&testCommand2{}); err != BusIsShuttingDownError {
+			t.Error("Expected BusIsShuttingDownError error.")
+		}
+		stopping.increment()
+		return nil
+	})
+	bus.OnStopped(func(bus *Bus) error {
+		if bus.State() != StateStopped {
+			t.Error("Expected the bus to be stopped.")
+		}
+		stopped.increment()
+		return nil
+	})
+
+	if err := bus.Initialize(hdl); err != nil {
+		t.Fatal(err.Error())
+	}
+	if bus.State() != StateRunning || bus.WorkerPoolSize() != 1 {
+		t.Error("Expected the bus to be running with the settings of the OnStart hook.")
+	}
+	if err := bus.Start(); err != BusIsRunningError {
+		t.Error("Expected BusIsRunningError error.")
+	} else if err.Error() != "command: the bus is already running" {
+		t.Error("Unexpected BusIsRunningError message.")
+	}
+	if _, err := bus.Schedule(&testCommand2{}, schedule.At(time.Now().Add(time.Hour))); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	if err := bus.Restart(ctx); err != nil {
+		t.Fatal(err.Error())
+	}
+	if bus.State() != StateRunning || bus.WorkerPoolSize() != 2 {
+		t.Error("Expected the bus to be running with the settings of the OnStart hook.")
+	}
+	if !started.is(2) || !stopping.is(1) || !stopped.is(1) {
+		t.Error("Unexpected number of hook invocations.")
+	}
+	if len(bus.scheduleProcessor.scheduledCommands) != 1 {
+		t.Error("Expected the scheduled commands to be kept.")
+	}
+	as, err := bus.HandleAsync(&testCommand2{})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if data, err := as.Await(); err != nil || data != "ok" {
+		t.Error(unexpectedDataError)
+	}
+
+	if err = bus.Stop(ctx); err != nil {
+		t.Fatal(err.Error())
+	}
+	if _, err = bus.Handle(&testCommand2{}); err != BusNotInitializedError {
+		t.Error("Expected BusNotInitializedError error.")
+	}
+	if err = bus.Initialize(hdl); err != nil {
+		t.Fatal(err.Error())
+	}
+	if data, err := bus.Handle(&testCommand2{}); err != nil || data != "ok" {
+		t.Error(unexpectedDataError)
+	}
+	if err = bus.Stop(ctx); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	hookErr := errors.New("hook failure")
+	bus.OnStart(func(bus *Bus) error {
+		return hookErr
+	})
+	if err = bus.Start(); err != hookErr {
+		t.Error("Expected the OnStart hook error.")
+	}
+	if bus.State() != StateStopped {
+		t.Error("Expected the bus to remain stopped.")
+	}
+}
+
+func TestBus_HandleIdempotent(t *testing.T) {
+	bus := NewBus()
+	bus.SetWorkerPoolSize(2)
+	hdl := newTestBlockingHandler(TestCommand1)
+	if err := bus.Initialize(hdl); err != nil {
+		t.Fatal(err.Error())
+	}
+	timeout := setupHandleTimeout(t)
+	as, _ := bus.HandleAsync(&testIdempotentCommand{"foo"})
+	<-hdl.started
+	duplicate, _ := bus.HandleAsync(&testIdempotentCommand{"foo"})
+	if bus.CoalescingStats().Coalesced != 1 {
+		t.Error("Expected the duplicate to be coalesced onto the in-flight command.")
+	}
+	other, _ := bus.HandleAsync(&testIdempotentCommand{"bar"})
+	<-hdl.started
+	hdl.release <- true
+	hdl.release <- true
+	if _, err := NewAsyncList(as, duplicate, other).Await(); err != nil {
+		t.Fatal(err.Error())
+	}
+	if !hdl.handled.is(2) {
+		t.Error("Expected the duplicate not to be handled.")
+	}
+
+	after, _ := bus.HandleAsync(&testIdempotentCommand{"foo"})
+	<-hdl.started
+	hdl.release <- true
+	_, _ = after.Await()
+	if !hdl.handled.is(3) {
+		t.Error("Expected processed commands not to be coalesced.")
+	}
+	timeout.Stop()
+}
+
+func TestBus_HandleCoalesced(t *testing.T) {
+	bus := NewBus()
+	hdl := newTestBlockingHandler(TestCommand1)
+	if err := bus.Initialize(hdl); err != nil {
+		t.Fatal(err.Error())
+	}
+	timeout := setupHandleTimeout(t)
+	errs := make(chan error, 2)
+	for i := 0; i < 2; i++ {
+		go func() {
+			_, err := bus.Handle(&testCoalescableCommand{"foo"})
+			errs <- err
+		}()
+	}
+	<-hdl.started
+	as, _ := bus.HandleAsync(&testCoalescableCommand{"foo"})
+	for bus.CoalescingStats().Coalesced != 2 {
+		time.Sleep(time.Millisecond)
+	}
+	hdl.release <- true
+	for i := 0; i < 2; i++ {
+		if err := <-errs; err != nil {
+			t.Error(err.Error())
+		}
+	}
+	if _, err := as.Await(); err != nil {
+		t.Error(err.Error())
+	}
+	if stats := bus.CoalescingStats(); !hdl.handled.is(1) || stats.Executions != 1 || stats.Coalesced != 2 {
+		t.Error("Expected the duplicates to share a single execution.")
+	}
+
+	go func() {
+		_, err := bus.Handle(&testCoalescableCommand{"foo"})
+		errs <- err
+	}()
+	<-hdl.started
+	hdl.release <- true
+	if err := <-errs; err != nil || !hdl.handled.is(2) {
+		t.Error("Expected processed commands not to be coalesced.")
+	}
+
+	// canceling a duplicate does not affect the shared execution
+	as, _ = bus.HandleAsync(&testCoalescableCommand{"foo"})
+	<-hdl.started
+	canceled, _ := bus.HandleAsync(&testCoalescableCommand{"foo"})
+	duplicate, _ := bus.HandleAsync(&testCoalescableCommand{"foo"})
+	if !canceled.Cancel() {
+		t.Error("Expected the duplicate to be canceled.")
+	}
+	if _, err := canceled.Await(); err != CommandCanceledError {
+		t.Error("Expected CommandCanceledError error.")
+	}
+	hdl.release <- true
+	if _, err := NewAsyncList(as, duplicate).Await(); err != nil || !hdl.handled.is(3) {
+		t.Error("Expected the shared execution to complete.")
+	}
+	timeout.Stop()
+}
+
+func TestMemoryIdempotencyStore(t *testing.T) {
+	store := NewMemoryIdempotencyStore()
+	if rec, _ := store.Load("foo"); rec != nil {
+		t.Error("Expected no record.")
+	}
+	_ = store.Store(&IdempotencyRecord{Key: "foo", Data: "bar"})
+	_ = store.Store(&IdempotencyRecord{Key: "baz", Data: "qux", ExpiresAt: time.Now().Add(-time.Second)})
+	if rec, _ := store.Load("foo"); rec == nil || rec.Data != "bar" {
+		t.Error("Expected the record to be loaded.")
+	}
+	if rec, _ := store.Load("baz"); rec != nil {
+		t.Error("Expected expired records not to be loaded.")
+	}
+}
+
+func TestFileIdempotencyStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "idempotency.json")
+	store, err := NewFileIdempotencyStore(path)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if err = store.Store(&IdempotencyRecord{Key: "foo", Identifier: TestCommand1, Data: "bar"}); err != nil {
+		t.Fatal(err.Error())
+	}
+	if err = store.Store(&IdempotencyRecord{Key: "baz", Data: "qux", ExpiresAt: time.Now().Add(time.Millisecond)}); err != nil {
+		t.Fatal(err.Error())
+	}
+	time.Sleep(2 * time.Millisecond)
+
+	reloaded, err := NewFileIdempotencyStore(path)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if rec, _ := reloaded.Load("foo"); rec == nil || rec.Data != "bar" || rec.Identifier != TestCommand1 {
+		t.Error("Expected the record to be persisted.")
+	}
+	if rec, _ := reloaded.Load("baz"); rec != nil {
+		t.Error("Expected expired records not to be loaded.")
+	}
 }
 
 func BenchmarkBus_Handle(b *testing.B) {
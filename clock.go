@@ -0,0 +1,25 @@
+package command
+
+import "time"
+
+// Clock provides the current time and the timers used by the scheduler, the timeouts and the time-dependent middlewares,
+// allowing the time to be controlled in tests (see github.com/io-da/command/clocktest).
+type Clock interface {
+	Now() time.Time
+	// AfterFunc waits for the duration to elapse and then calls f.
+	// It returns a function that stops the timer, reporting whether f was prevented from being called.
+	AfterFunc(d time.Duration, f func()) (stop func() bool)
+}
+
+// SystemClock is the Clock used by default, backed by the time package.
+type SystemClock struct{}
+
+// Now returns the current local time.
+func (SystemClock) Now() time.Time {
+	return time.Now()
+}
+
+// AfterFunc calls f in its own goroutine once the duration elapses.
+func (SystemClock) AfterFunc(d time.Duration, f func()) func() bool {
+	return time.AfterFunc(d, f).Stop
+}
@@ -0,0 +1,123 @@
+// Package clocktest provides a fake clock, allowing the scheduler, the timeouts and the middlewares of the bus to be
+// tested deterministically instead of in real time.
+package clocktest
+
+import (
+	"slices"
+	"sync"
+	"time"
+)
+
+// FakeClock is a clock that only advances when instructed to.
+// It implements the command.Clock interface and may be provided to the bus using SetClock.
+type FakeClock struct {
+	sync.Mutex
+	changed *sync.Cond
+	now     time.Time
+	timers  []*timer
+}
+
+type timer struct {
+	deadline time.Time
+	f        func()
+}
+
+// NewFakeClock instantiates the FakeClock struct, starting at the provided time.
+func NewFakeClock(now time.Time) *FakeClock {
+	clock := &FakeClock{
+		now:    now,
+		timers: make([]*timer, 0),
+	}
+	clock.changed = sync.NewCond(&clock.Mutex)
+	return clock
+}
+
+// Now returns the current time of the clock.
+func (clock *FakeClock) Now() time.Time {
+	clock.Lock()
+	defer clock.Unlock()
+	return clock.now
+}
+
+// AfterFunc calls f once the clock is advanced past the duration.
+// Unlike the timers of the time package, f is called synchronously by Advance (or immediately if the duration is not
+// positive). It returns a function that stops the timer, reporting whether f was prevented from being called.
+func (clock *FakeClock) AfterFunc(d time.Duration, f func()) func() bool {
+	if d <= 0 {
+		f()
+		return func() bool {
+			return false
+		}
+	}
+	clock.Lock()
+	t := &timer{
+		deadline: clock.now.Add(d),
+		f:        f,
+	}
+	clock.timers = append(clock.timers, t)
+	clock.changed.Broadcast()
+	clock.Unlock()
+	return func() bool {
+		clock.Lock()
+		defer clock.Unlock()
+		return clock.remove(t)
+	}
+}
+
+// Advance moves the clock forward by the provided duration, calling the functions of the timers that elapse in the
+// order of their deadlines.
+func (clock *FakeClock) Advance(d time.Duration) {
+	clock.Set(clock.Now().Add(d))
+}
+
+// Set moves the clock to the provided time, calling the functions of the timers that elapse in the order of their
+// deadlines.
+func (clock *FakeClock) Set(now time.Time) {
+	clock.Lock()
+	clock.now = now
+	var elapsed []*timer
+	for _, t := range clock.timers {
+		if !t.deadline.After(now) {
+			elapsed = append(elapsed, t)
+		}
+	}
+	for _, t := range elapsed {
+		clock.remove(t)
+	}
+	clock.changed.Broadcast()
+	clock.Unlock()
+	slices.SortStableFunc(elapsed, func(a, b *timer) int {
+		return a.deadline.Compare(b.deadline)
+	})
+	for _, t := range elapsed {
+		t.f()
+	}
+}
+
+// Timers returns the number of timers waiting for the clock to advance.
+func (clock *FakeClock) Timers() int {
+	clock.Lock()
+	defer clock.Unlock()
+	return len(clock.timers)
+}
+
+// BlockUntil waits until at least n timers are waiting for the clock to advance.
+// It allows tests to wait for the bus to arm its timers (e.g. the scheduler going to sleep) before advancing the clock.
+func (clock *FakeClock) BlockUntil(n int) {
+	clock.Lock()
+	for len(clock.timers) < n {
+		clock.changed.Wait()
+	}
+	clock.Unlock()
+}
+
+//------Internal------//
+
+func (clock *FakeClock) remove(t *timer) bool {
+	i := slices.Index(clock.timers, t)
+	if i < 0 {
+		return false
+	}
+	clock.timers = slices.Delete(clock.timers, i, i+1)
+	return true
+}
@@ -0,0 +1,58 @@
+package clocktest
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFakeClock_Advance(t *testing.T) {
+	start := time.Date(2024, time.January, 1, 0, 0, 0, 0, time.UTC)
+	clock := NewFakeClock(start)
+	var fired []int
+	clock.AfterFunc(2*time.Second, func() {
+		fired = append(fired, 2)
+	})
+	clock.AfterFunc(time.Second, func() {
+		fired = append(fired, 1)
+	})
+	stop := clock.AfterFunc(3*time.Second, func() {
+		fired = append(fired, 3)
+	})
+	if clock.Timers() != 3 {
+		t.Error("Expected 3 timers.")
+	}
+
+	clock.Advance(500 * time.Millisecond)
+	if len(fired) != 0 || !clock.Now().Equal(start.Add(500*time.Millisecond)) {
+		t.Error("Expected no timer to elapse.")
+	}
+	clock.Advance(2 * time.Second)
+	if len(fired) != 2 || fired[0] != 1 || fired[1] != 2 {
+		t.Error("Expected the timers to elapse in the order of their deadlines.")
+	}
+	if !stop() || stop() {
+		t.Error("Expected the timer to be stopped once.")
+	}
+	clock.Advance(time.Hour)
+	if len(fired) != 2 || clock.Timers() != 0 {
+		t.Error("Expected the stopped timer not to elapse.")
+	}
+
+	clock.AfterFunc(0, func() {
+		fired = append(fired, 0)
+	})
+	if len(fired) != 3 {
+		t.Error("Expected the due timer to elapse immediately.")
+	}
+}
+
+func TestFakeClock_BlockUntil(t *testing.T) {
+	clock := NewFakeClock(time.Now())
+	elapsed := make(chan bool)
+	go clock.AfterFunc(time.Minute, func() {
+		close(elapsed)
+	})
+	clock.BlockUntil(1)
+	clock.Advance(time.Minute)
+	<-elapsed
+}
@@ -22,3 +22,6 @@ func (hdl *ClosureHandler) Handle(cmd Command) (data any, err error) {
 	}
 	return nil, InvalidClosureCommandError
 }
+
+// closureHandler is used to handle closures when no other handler is registered for them.
+var closureHandler = newContextHandler(&ClosureHandler{})
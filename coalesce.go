@@ -0,0 +1,100 @@
+package command
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// Coalescable may optionally be implemented by commands whose identical concurrent executions should be shared.
+// Concurrent calls to Handle or HandleAsync with the same identifier and coalesce key share a single execution of the
+// handler: duplicates issued while the command is in-flight receive the same result or error.
+// The execution belongs to the first call: if it is canceled or its context is done, the duplicates fail along with it.
+// Canceling the *Async of a duplicate only resolves that duplicate with CommandCanceledError.
+// Unlike Idempotent commands, the result is not shared once the command is processed.
+type Coalescable interface {
+	CoalesceKey() string
+}
+
+// CoalescingStats holds the metrics of the commands sharing the execution of an identical command in-flight.
+type CoalescingStats struct {
+	// Executions is the number of idempotent or coalescable commands that were executed.
+	Executions uint64
+	// Coalesced is the number of calls that shared the execution of a command in-flight, instead of being executed.
+	Coalesced uint64
+}
+
+//------Internal------//
+
+func coalesceKeyOf(cmd Command) (string, bool) {
+	coalescable, ok := cmd.(Coalescable)
+	if !ok {
+		return "", false
+	}
+	return string(cmd.Identifier()) + "/" + coalescable.CoalesceKey(), true
+}
+
+// inFlightKeys returns the keys identifying the duplicates of the command.
+func inFlightKeys(cmd Command) []string {
+	var keys []string
+	if key, ok := IdempotencyKeyOf(cmd); ok {
+		keys = append(keys, "idempotency:"+key)
+	}
+	if key, ok := coalesceKeyOf(cmd); ok {
+		keys = append(keys, "coalesce:"+key)
+	}
+	return keys
+}
+
+// inFlight tracks the idempotent and coalescable commands being processed, to coalesce their duplicates.
+type inFlight struct {
+	sync.Mutex
+	asyncs     map[string]*Async
+	executions atomic.Uint64
+	coalesced  atomic.Uint64
+}
+
+func newInFlight() *inFlight {
+	return &inFlight{
+		asyncs: make(map[string]*Async),
+	}
+}
+
+// coalesce reports whether the command has a duplicate in-flight, in which case the *Async follows its execution.
+// Otherwise, the command is tracked until it is processed.
+func (flight *inFlight) coalesce(async *Async) (*Async, bool) {
+	keys := inFlightKeys(async.cmd)
+	if len(keys) == 0 {
+		return async, false
+	}
+	flight.Lock()
+	for _, key := range keys {
+		if existing, ok := flight.asyncs[key]; ok {
+			flight.Unlock()
+			flight.coalesced.Add(1)
+			async.follow(existing)
+			return async, true
+		}
+	}
+	for _, key := range keys {
+		flight.asyncs[key] = async
+	}
+	flight.Unlock()
+	flight.executions.Add(1)
+	async.addListener(func(as *Async) {
+		flight.Lock()
+		for _, key := range keys {
+			if flight.asyncs[key] == as {
+				delete(flight.asyncs, key)
+			}
+		}
+		flight.Unlock()
+	})
+	return async, false
+}
+
+func (flight *inFlight) stats() CoalescingStats {
+	return CoalescingStats{
+		Executions: flight.executions.Load(),
+		Coalesced:  flight.coalesced.Load(),
+	}
+}
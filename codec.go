@@ -0,0 +1,65 @@
+package command
+
+import (
+	"encoding/json"
+	"reflect"
+	"sync"
+)
+
+// CommandCodec is used to serialize and deserialize commands by their identifier.
+// It is required by the components of the bus that persist commands.
+type CommandCodec interface {
+	Encode(cmd Command) ([]byte, error)
+	Decode(identifier Identifier, data []byte) (Command, error)
+}
+
+// JSONCodec is a CommandCodec that serializes commands using encoding/json.
+// The commands must be registered to be decoded.
+type JSONCodec struct {
+	sync.RWMutex
+	types map[Identifier]reflect.Type
+}
+
+// NewJSONCodec instantiates the JSONCodec struct.
+func NewJSONCodec() *JSONCodec {
+	return &JSONCodec{
+		types: make(map[Identifier]reflect.Type),
+	}
+}
+
+// Register the commands that the codec is able to decode.
+// The provided commands are only used as a reference of their type and identifier.
+func (codec *JSONCodec) Register(cmds ...Command) {
+	codec.Lock()
+	for _, cmd := range cmds {
+		codec.types[cmd.Identifier()] = reflect.TypeOf(cmd)
+	}
+	codec.Unlock()
+}
+
+// Encode serializes the command to JSON.
+func (codec *JSONCodec) Encode(cmd Command) ([]byte, error) {
+	return json.Marshal(cmd)
+}
+
+// Decode deserializes the JSON data into a new command of the type registered for the identifier.
+func (codec *JSONCodec) Decode(identifier Identifier, data []byte) (Command, error) {
+	codec.RLock()
+	typ, ok := codec.types[identifier]
+	codec.RUnlock()
+	if !ok {
+		return nil, CommandNotRegisteredError
+	}
+	if typ.Kind() == reflect.Pointer {
+		value := reflect.New(typ.Elem())
+		if err := json.Unmarshal(data, value.Interface()); err != nil {
+			return nil, err
+		}
+		return value.Interface().(Command), nil
+	}
+	value := reflect.New(typ)
+	if err := json.Unmarshal(data, value.Interface()); err != nil {
+		return nil, err
+	}
+	return value.Elem().Interface().(Command), nil
+}
@@ -0,0 +1,148 @@
+package command
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Stage identifies where the processing of a command failed.
+type Stage string
+
+const (
+	// StageLookup is the stage in which the handler of the command is resolved.
+	StageLookup Stage = "lookup"
+	// StageQueue is the stage in which async commands are queued or waiting to be processed.
+	StageQueue Stage = "queue"
+	// StageSchedule is the stage in which scheduled commands are issued by the scheduler.
+	StageSchedule Stage = "schedule"
+	// StageMiddleware is the stage in which the command is processed by the middlewares.
+	StageMiddleware Stage = "middleware"
+	// StageHandler is the stage in which the command is processed by its handler.
+	StageHandler Stage = "handler"
+	// StageTimeout is used when the command is not processed within its timeout.
+	StageTimeout Stage = "timeout"
+	// StagePanic is used when a panic is recovered while processing the command.
+	StagePanic Stage = "panic"
+	// StageDeadLetter is the stage in which failed commands are stored as dead letters.
+	StageDeadLetter Stage = "dead letter"
+	// StageShutdown is used for the commands abandoned while the bus is shutting down.
+	StageShutdown Stage = "shutdown"
+	// StageLifecycle is used for the errors of the lifecycle hooks, which are not related to any command.
+	StageLifecycle Stage = "lifecycle"
+	// StageReported is used for the errors reported through ReportError.
+	StageReported Stage = "reported"
+)
+
+// CommandError wraps every error passed on to the error handlers, describing where the processing of the command failed.
+// The original error is available through errors.Is and errors.As.
+type CommandError struct {
+	// Identifier of the command (if any).
+	Identifier Identifier
+	// Stage in which the processing failed.
+	Stage Stage
+	// Middleware is the type of the middleware that failed, when the stage is StageMiddleware.
+	Middleware string
+	// MiddlewareIndex is the position of the middleware that failed, or -1 if the failure did not originate from a middleware.
+	MiddlewareIndex int
+	// Async is true for commands processed asynchronously, including scheduled commands.
+	Async bool
+	// ScheduleKey is the key of the schedule that issued the command, or uuid.Nil if it was not scheduled.
+	ScheduleKey uuid.UUID
+	// Attempt is the number of times the command was attempted, including redrives.
+	Attempt int
+	// Duration is the time elapsed since the handling of the command started, or zero if it did not start.
+	Duration time.Duration
+	// Err is the original error.
+	Err error
+
+	command  Command
+	envelope *Envelope
+}
+
+// Error returns the string message of the error.
+func (e *CommandError) Error() string {
+	if e.Middleware != "" {
+		return fmt.Sprintf("command: %s failed at %s %d (%s): %v", e.Identifier, e.Stage, e.MiddlewareIndex, e.Middleware, e.Err)
+	}
+	return fmt.Sprintf("command: %s failed at %s: %v", e.Identifier, e.Stage, e.Err)
+}
+
+// Unwrap returns the original error.
+func (e *CommandError) Unwrap() error {
+	return e.Err
+}
+
+//------Internal------//
+
+// execution describes how a command is being processed, it is carried by the context.
+type execution struct {
+	async     *Async
+	scheduled bool
+	clock     Clock
+	startedAt time.Time
+}
+
+type executionKey struct{}
+
+func withExecution(ctx context.Context, exec *execution) context.Context {
+	return context.WithValue(ctx, executionKey{}, exec)
+}
+
+// startExecution marks the start of the handling of the command.
+func startExecution(ctx context.Context, clock Clock) context.Context {
+	started := &execution{}
+	if exec, ok := ctx.Value(executionKey{}).(*execution); ok {
+		*started = *exec
+	}
+	started.clock = clock
+	started.startedAt = clock.Now()
+	return withExecution(ctx, started)
+}
+
+// detach removes the cancellation of the command being processed with the context (if any), keeping its values.
+// Async commands issued while processing another one outlive it, its timeout must not cancel them while queued.
+func detach(ctx context.Context) context.Context {
+	if exec, ok := ctx.Value(executionKey{}).(*execution); ok && !exec.startedAt.IsZero() {
+		return context.WithoutCancel(ctx)
+	}
+	return ctx
+}
+
+func newCommandError(ctx context.Context, cmd Command, stage Stage, err error) *CommandError {
+	if cmdErr, ok := err.(*CommandError); ok {
+		return cmdErr
+	}
+	cmdErr := &CommandError{
+		Stage:           stage,
+		MiddlewareIndex: -1,
+		Attempt:         1,
+		Err:             err,
+		command:         cmd,
+	}
+	cmdErr.envelope, _ = EnvelopeFromContext(ctx)
+	if cmd != nil {
+		cmdErr.Identifier = cmd.Identifier()
+	}
+	if exec, ok := ctx.Value(executionKey{}).(*execution); ok {
+		cmdErr.Async = exec.scheduled || exec.async != nil
+		if exec.async != nil {
+			cmdErr.Attempt = exec.async.attempt
+			cmdErr.ScheduleKey = exec.async.scheduleKey
+		}
+		if !exec.startedAt.IsZero() {
+			cmdErr.Duration = exec.clock.Now().Sub(exec.startedAt)
+		}
+	}
+	return cmdErr
+}
+
+// middlewareName returns the type of the middleware as provided to the bus.
+func middlewareName(mdl ContextMiddleware) string {
+	if adapted, ok := mdl.(*contextMiddleware); ok {
+		return fmt.Sprintf("%T", adapted.Middleware)
+	}
+	return fmt.Sprintf("%T", mdl)
+}
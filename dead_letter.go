@@ -0,0 +1,85 @@
+package command
+
+import (
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// DeadLetter holds an async (or scheduled) command that failed to be processed.
+type DeadLetter struct {
+	ID         uuid.UUID
+	Identifier Identifier
+	Command    Command
+	Err        error
+	Attempts   int
+	IssuedAt   time.Time
+	FailedAt   time.Time
+}
+
+// DeadLetterFilter is used to select the dead letters to be redriven.
+type DeadLetterFilter func(dl *DeadLetter) bool
+
+// DeadLetterStore must be implemented for a type to qualify as a dead letter store.
+// The dead letters are expected to be loaded in the order they were stored.
+type DeadLetterStore interface {
+	Store(dl *DeadLetter) error
+	Load() ([]*DeadLetter, error)
+	Remove(ids ...uuid.UUID) error
+}
+
+// MemoryDeadLetterStore is a DeadLetterStore that keeps the dead letters in memory.
+type MemoryDeadLetterStore struct {
+	sync.Mutex
+	deadLetters []*DeadLetter
+}
+
+// NewMemoryDeadLetterStore instantiates the MemoryDeadLetterStore struct.
+func NewMemoryDeadLetterStore() *MemoryDeadLetterStore {
+	return &MemoryDeadLetterStore{
+		deadLetters: make([]*DeadLetter, 0),
+	}
+}
+
+// Store appends the dead letter to the store.
+func (store *MemoryDeadLetterStore) Store(dl *DeadLetter) error {
+	store.Lock()
+	store.deadLetters = append(store.deadLetters, dl)
+	store.Unlock()
+	return nil
+}
+
+// Load returns all the stored dead letters.
+func (store *MemoryDeadLetterStore) Load() ([]*DeadLetter, error) {
+	store.Lock()
+	defer store.Unlock()
+	return append([]*DeadLetter(nil), store.deadLetters...), nil
+}
+
+// Remove deletes the dead letters with the provided ids from the store.
+func (store *MemoryDeadLetterStore) Remove(ids ...uuid.UUID) error {
+	store.Lock()
+	store.deadLetters = removeDeadLetters(store.deadLetters, ids)
+	store.Unlock()
+	return nil
+}
+
+//------Internal------//
+
+func removeDeadLetters(deadLetters []*DeadLetter, ids []uuid.UUID) []*DeadLetter {
+	remaining := deadLetters[:0]
+	for _, dl := range deadLetters {
+		removed := false
+		for _, id := range ids {
+			if dl.ID == id {
+				removed = true
+				break
+			}
+		}
+		if !removed {
+			remaining = append(remaining, dl)
+		}
+	}
+	return remaining
+}
@@ -0,0 +1,152 @@
+package command
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// FileDeadLetterStore is a DeadLetterStore that persists the dead letters to a JSON file.
+// The commands are serialized using the provided CommandCodec.
+type FileDeadLetterStore struct {
+	sync.Mutex
+	path        string
+	codec       CommandCodec
+	deadLetters []*DeadLetter
+}
+
+// NewFileDeadLetterStore instantiates the FileDeadLetterStore struct.
+// Previously persisted dead letters are loaded from the file, if it exists.
+func NewFileDeadLetterStore(path string, codec CommandCodec) (*FileDeadLetterStore, error) {
+	store := &FileDeadLetterStore{
+		path:        path,
+		codec:       codec,
+		deadLetters: make([]*DeadLetter, 0),
+	}
+	if err := store.read(); err != nil {
+		return nil, err
+	}
+	return store, nil
+}
+
+// Store appends the dead letter to the store and persists it.
+func (store *FileDeadLetterStore) Store(dl *DeadLetter) error {
+	store.Lock()
+	defer store.Unlock()
+	deadLetters := append(store.deadLetters, dl)
+	if err := store.write(deadLetters); err != nil {
+		return err
+	}
+	store.deadLetters = deadLetters
+	return nil
+}
+
+// Load returns all the stored dead letters.
+func (store *FileDeadLetterStore) Load() ([]*DeadLetter, error) {
+	store.Lock()
+	defer store.Unlock()
+	return append([]*DeadLetter(nil), store.deadLetters...), nil
+}
+
+// Remove deletes the dead letters with the provided ids from the store.
+func (store *FileDeadLetterStore) Remove(ids ...uuid.UUID) error {
+	store.Lock()
+	defer store.Unlock()
+	deadLetters := removeDeadLetters(append([]*DeadLetter(nil), store.deadLetters...), ids)
+	if err := store.write(deadLetters); err != nil {
+		return err
+	}
+	store.deadLetters = deadLetters
+	return nil
+}
+
+//------Internal------//
+
+type deadLetterRecord struct {
+	ID         uuid.UUID       `json:"id"`
+	Identifier Identifier      `json:"identifier"`
+	Command    json.RawMessage `json:"command"`
+	Err        string          `json:"error"`
+	Attempts   int             `json:"attempts"`
+	IssuedAt   time.Time       `json:"issued_at"`
+	FailedAt   time.Time       `json:"failed_at"`
+}
+
+func (store *FileDeadLetterStore) read() error {
+	data, err := os.ReadFile(store.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var records []deadLetterRecord
+	if err = json.Unmarshal(data, &records); err != nil {
+		return err
+	}
+	for _, rec := range records {
+		cmd, err := store.codec.Decode(rec.Identifier, rec.Command)
+		if err != nil {
+			return err
+		}
+		store.deadLetters = append(store.deadLetters, &DeadLetter{
+			ID:         rec.ID,
+			Identifier: rec.Identifier,
+			Command:    cmd,
+			Err:        errors.New(rec.Err),
+			Attempts:   rec.Attempts,
+			IssuedAt:   rec.IssuedAt,
+			FailedAt:   rec.FailedAt,
+		})
+	}
+	return nil
+}
+
+func (store *FileDeadLetterStore) write(deadLetters []*DeadLetter) error {
+	records := make([]deadLetterRecord, len(deadLetters))
+	for i, dl := range deadLetters {
+		cmd, err := store.codec.Encode(dl.Command)
+		if err != nil {
+			return err
+		}
+		records[i] = deadLetterRecord{
+			ID:         dl.ID,
+			Identifier: dl.Identifier,
+			Command:    cmd,
+			Attempts:   dl.Attempts,
+			IssuedAt:   dl.IssuedAt,
+			FailedAt:   dl.FailedAt,
+		}
+		if dl.Err != nil {
+			records[i].Err = dl.Err.Error()
+		}
+	}
+	data, err := json.Marshal(records)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(store.path, data)
+}
+
+// writeFileAtomic replaces the file contents through a temporary file, preventing partially written files.
+func writeFileAtomic(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	if _, err = tmp.Write(data); err != nil {
+		_ = tmp.Close()
+		_ = os.Remove(tmp.Name())
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		_ = os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
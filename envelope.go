@@ -0,0 +1,122 @@
+package command
+
+import (
+	"context"
+	"maps"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Envelope wraps a command with the metadata of its execution.
+// Every command processed by the bus is enveloped, the envelope is available through the context using EnvelopeFromContext.
+// An Envelope is also a Command, it may be provided to the bus directly to issue a command with custom metadata.
+// Handlers and middlewares always receive the original command.
+type Envelope struct {
+	// ID uniquely identifies the execution of the command.
+	ID uuid.UUID
+	// CorrelationID identifies the whole chain of commands, it matches the ID of the first envelope of the chain.
+	CorrelationID uuid.UUID
+	// CausationID is the ID of the envelope of the command that issued this one (if any).
+	CausationID uuid.UUID
+	// CreatedAt is the time at which the envelope was created.
+	CreatedAt time.Time
+	// Headers may be used to attach any additional metadata, such as the issuer of the command.
+	Headers map[string]string
+	// Command is the original command.
+	Command Command
+}
+
+// NewEnvelope creates a new envelope for the command.
+func NewEnvelope(cmd Command) *Envelope {
+	return newEnvelope(cmd, time.Now())
+}
+
+// Identifier returns the identifier of the original command.
+func (env *Envelope) Identifier() Identifier {
+	if env.Command == nil {
+		return ""
+	}
+	return env.Command.Identifier()
+}
+
+// WithHeader sets the header and returns the envelope, allowing the calls to be chained.
+func (env *Envelope) WithHeader(key string, value string) *Envelope {
+	if env.Headers == nil {
+		env.Headers = make(map[string]string)
+	}
+	env.Headers[key] = value
+	return env
+}
+
+// Header returns the value of the header or an empty string if it is not set.
+func (env *Envelope) Header(key string) string {
+	return env.Headers[key]
+}
+
+// EnvelopeFromContext returns the envelope of the command being processed with the context.
+func EnvelopeFromContext(ctx context.Context) (*Envelope, bool) {
+	env, ok := ctx.Value(envelopeKey{}).(*Envelope)
+	return env, ok
+}
+
+//------Internal------//
+
+type envelopeKey struct{}
+
+func newEnvelope(cmd Command, createdAt time.Time) *Envelope {
+	return &Envelope{
+		ID:        uuid.New(),
+		CreatedAt: createdAt,
+		Headers:   make(map[string]string),
+		Command:   cmd,
+	}
+}
+
+// renew copies the envelope with a new identity, used for each execution of scheduled envelopes.
+func (env *Envelope) renew(now time.Time) *Envelope {
+	renewed := *env
+	if env.CorrelationID == env.ID {
+		// root envelopes start their own correlation
+		renewed.CorrelationID = uuid.Nil
+	}
+	renewed.ID = uuid.New()
+	renewed.CreatedAt = now
+	renewed.Headers = maps.Clone(env.Headers)
+	return &renewed
+}
+
+// unwrap returns the original command of envelopes.
+func unwrap(cmd Command) Command {
+	if env, ok := cmd.(*Envelope); ok {
+		if env == nil {
+			return nil
+		}
+		return env.Command
+	}
+	return cmd
+}
+
+// envelop wraps the command in an envelope (unless it is one already) and attaches it to the context.
+// If the context already carries an envelope, the command was issued while processing another one,
+// so the correlation is propagated.
+func envelop(ctx context.Context, cmd Command, now time.Time) (context.Context, *Envelope) {
+	env, ok := cmd.(*Envelope)
+	if !ok {
+		env = newEnvelope(cmd, now)
+	} else if env == nil {
+		env = newEnvelope(nil, now)
+	}
+	if parent, ok := EnvelopeFromContext(ctx); ok && parent != env {
+		if env.CorrelationID == uuid.Nil {
+			env.CorrelationID = parent.CorrelationID
+		}
+		if env.CausationID == uuid.Nil {
+			env.CausationID = parent.ID
+		}
+	}
+	if env.CorrelationID == uuid.Nil {
+		env.CorrelationID = env.ID
+	}
+	return context.WithValue(ctx, envelopeKey{}, env), env
+}
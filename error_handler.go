@@ -4,3 +4,10 @@ package command
 type ErrorHandler interface {
 	Handle(cmd Command, err error)
 }
+
+// EnvelopeErrorHandler may optionally be implemented by error handlers to also receive the envelope of the command.
+// When the envelope is available, HandleEnvelope is used instead of Handle.
+type EnvelopeErrorHandler interface {
+	ErrorHandler
+	HandleEnvelope(env *Envelope, err error)
+}
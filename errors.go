@@ -1,5 +1,7 @@
 package command
 
+import "fmt"
+
 // BusError is used to create errors originating from the command bus
 type BusError string
 
@@ -13,6 +15,12 @@ const (
 	InvalidCommandError = BusError("command: invalid command")
 	// BusNotInitializedError will be returned when attempting to handle a command before the bus is initialized.
 	BusNotInitializedError = BusError("command: the bus is not initialized")
+	// BusIsInitializedError is no longer returned, handlers may be registered at any time (see Bus.Register).
+	//
+	// Deprecated: initializing a bus that is already running has no effect.
+	BusIsInitializedError = BusError("command: the bus is already initialized")
+	// BusIsRunningError will be returned when attempting to start a bus that is already running.
+	BusIsRunningError = BusError("command: the bus is already running")
 	// BusIsShuttingDownError will be returned when attempting to handle a command while the bus is shutting down.
 	BusIsShuttingDownError = BusError("command: the bus is shutting down")
 	// OneHandlerPerCommandError will be returned when attempting to initialize the bus with more than one handler listening to the same command.
@@ -23,4 +31,61 @@ const (
 	EmptyAwaitListError = BusError("command: await list is empty")
 	// InvalidClosureCommandError will be returned when attempting to handle a command with the closure identifier but invalid type
 	InvalidClosureCommandError = BusError("command: invalid closure command")
+	// AwaitTimeoutError will be returned when the deadline to await an async command is exceeded.
+	AwaitTimeoutError = BusError("command: timed out awaiting the command")
+	// TimeoutError will be returned when the command is not processed within its timeout.
+	TimeoutError = BusError("command: the command timed out")
+	// AwaitCanceledError will be returned when awaiting an async command is canceled.
+	AwaitCanceledError = BusError("command: awaiting the command was canceled")
+	// CommandCanceledError will be returned when awaiting an async command that was canceled before being processed.
+	CommandCanceledError = BusError("command: the command was canceled")
+	// QueueFullError will be returned when attempting to handle an async command while the queue is full, according to the overflow policy.
+	QueueFullError = BusError("command: the async commands queue is full")
+	// CommandDroppedError will be returned when awaiting an async command that was dropped from a full queue.
+	CommandDroppedError = BusError("command: the command was dropped from the full queue")
+	// DeadLetterStoreNotSetError will be returned when attempting to redrive commands without a dead letter store.
+	DeadLetterStoreNotSetError = BusError("command: no dead letter store was provided")
+	// CommandNotRegisteredError will be returned when attempting to decode a command that was not registered in the codec.
+	CommandNotRegisteredError = BusError("command: the command is not registered in the codec")
+	// ScheduledCommandNotFoundError will be returned when attempting to inspect or reschedule a scheduled command that does not exist.
+	ScheduledCommandNotFoundError = BusError("command: the scheduled command was not found")
+	// ScheduledCommandReplacedError is the cause of the context of scheduled commands canceled due to the OverlapReplace policy.
+	ScheduledCommandReplacedError = BusError("command: the scheduled command was replaced by its next run")
 )
+
+// PanicError will be returned when a panic is recovered while handling a command.
+// It carries the recovered value along with the stack trace of the panic.
+type PanicError struct {
+	Value any
+	Stack []byte
+}
+
+// Error returns the string message of the error.
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("command: panic recovered: %v", e.Value)
+}
+
+// Unwrap returns the recovered value if it is an error.
+func (e *PanicError) Unwrap() error {
+	if err, ok := e.Value.(error); ok {
+		return err
+	}
+	return nil
+}
+
+// ShutdownError will be returned when the bus could not be shutdown gracefully before the context was done.
+// It holds the number of queued commands that were abandoned.
+type ShutdownError struct {
+	Abandoned int
+	Err       error
+}
+
+// Error returns the string message of the error.
+func (e *ShutdownError) Error() string {
+	return fmt.Sprintf("command: shutdown interrupted (%d commands abandoned): %v", e.Abandoned, e.Err)
+}
+
+// Unwrap returns the error of the context.
+func (e *ShutdownError) Unwrap() error {
+	return e.Err
+}
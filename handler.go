@@ -1,7 +1,47 @@
 package command
 
+import (
+	"context"
+	"reflect"
+)
+
 // Handler must be implemented for a type to qualify as a command handler.
 type Handler interface {
 	Handle(cmd Command) (any, error)
 	Handles() Identifier
 }
+
+// ContextHandler may optionally be implemented by handlers that require the context the command was issued with.
+// The bus will use HandleContext instead of Handle to process the commands of these handlers.
+type ContextHandler interface {
+	Handler
+	HandleContext(ctx context.Context, cmd Command) (any, error)
+}
+
+// contextHandler adapts the context-free handlers to the ContextHandler interface.
+type contextHandler struct {
+	Handler
+}
+
+func newContextHandler(hdl Handler) ContextHandler {
+	if ctxHdl, ok := hdl.(ContextHandler); ok {
+		return ctxHdl
+	}
+	return &contextHandler{hdl}
+}
+
+func (hdl *contextHandler) HandleContext(_ context.Context, cmd Command) (any, error) {
+	return hdl.Handle(cmd)
+}
+
+// isHandler reports whether the registered handler is the provided one.
+func isHandler(registered ContextHandler, hdl Handler) bool {
+	var original Handler = registered
+	if adapted, ok := registered.(*contextHandler); ok {
+		original = adapted.Handler
+	}
+	if !reflect.TypeOf(original).Comparable() || reflect.TypeOf(original) != reflect.TypeOf(hdl) {
+		return false
+	}
+	return original == hdl
+}
@@ -0,0 +1,107 @@
+package command
+
+import (
+	"sync"
+	"time"
+)
+
+// Idempotent may optionally be implemented by commands that should only be processed once per idempotency key.
+// Async commands with the same identifier and idempotency key are coalesced while in-flight: issuing a duplicate returns
+// an *Async that shares the execution of the command being processed (see Coalescable). To also deduplicate commands
+// after they are processed, use the idempotency middleware (github.com/io-da/command/middleware/idempotency).
+type Idempotent interface {
+	IdempotencyKey() string
+}
+
+// IdempotencyKeyOf returns the idempotency key of the command, scoped by its identifier.
+// It returns false if the command is not Idempotent.
+func IdempotencyKeyOf(cmd Command) (string, bool) {
+	idempotent, ok := cmd.(Idempotent)
+	if !ok {
+		return "", false
+	}
+	return string(cmd.Identifier()) + "/" + idempotent.IdempotencyKey(), true
+}
+
+// IdempotencyRecord holds the result of a processed idempotent command.
+type IdempotencyRecord struct {
+	Key        string
+	Identifier Identifier
+	Data       any
+	StoredAt   time.Time
+	ExpiresAt  time.Time
+}
+
+// expired reports whether the record is expired, records without expiration never expire.
+func (rec *IdempotencyRecord) expired(now time.Time) bool {
+	return !rec.ExpiresAt.IsZero() && !now.Before(rec.ExpiresAt)
+}
+
+// IdempotencyStore must be implemented for a type to qualify as a store of the results of idempotent commands.
+type IdempotencyStore interface {
+	// Load returns the record with the provided key, or nil if it does not exist or is expired.
+	Load(key string) (*IdempotencyRecord, error)
+	Store(rec *IdempotencyRecord) error
+}
+
+// MemoryIdempotencyStore is an IdempotencyStore that keeps the records in memory.
+// Expired records are purged periodically while storing new ones.
+type MemoryIdempotencyStore struct {
+	sync.Mutex
+	records    map[string]*IdempotencyRecord
+	clock      Clock
+	lastPurged time.Time
+}
+
+// idempotencyPurgeInterval is the minimum interval between purges of the expired records.
+const idempotencyPurgeInterval = time.Minute
+
+// NewMemoryIdempotencyStore instantiates the MemoryIdempotencyStore struct.
+func NewMemoryIdempotencyStore() *MemoryIdempotencyStore {
+	return &MemoryIdempotencyStore{
+		records:    make(map[string]*IdempotencyRecord),
+		clock:      SystemClock{},
+		lastPurged: time.Now(),
+	}
+}
+
+// SetClock may optionally be used to provide the clock used to expire the records, usually the clock of the bus.
+// It defaults to SystemClock.
+func (store *MemoryIdempotencyStore) SetClock(clock Clock) {
+	store.Lock()
+	defer store.Unlock()
+	store.clock = clock
+	store.lastPurged = clock.Now()
+}
+
+// Load returns the record with the provided key, or nil if it does not exist or is expired.
+func (store *MemoryIdempotencyStore) Load(key string) (*IdempotencyRecord, error) {
+	store.Lock()
+	defer store.Unlock()
+	rec, ok := store.records[key]
+	if !ok {
+		return nil, nil
+	}
+	if rec.expired(store.clock.Now()) {
+		delete(store.records, key)
+		return nil, nil
+	}
+	return rec, nil
+}
+
+// Store adds the record to the store, replacing any record with the same key.
+func (store *MemoryIdempotencyStore) Store(rec *IdempotencyRecord) error {
+	store.Lock()
+	defer store.Unlock()
+	now := store.clock.Now()
+	if now.Sub(store.lastPurged) >= idempotencyPurgeInterval {
+		for key, stored := range store.records {
+			if stored.expired(now) {
+				delete(store.records, key)
+			}
+		}
+		store.lastPurged = now
+	}
+	store.records[rec.Key] = rec
+	return nil
+}
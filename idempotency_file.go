@@ -0,0 +1,132 @@
+package command
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"sync"
+	"time"
+)
+
+// FileIdempotencyStore is an IdempotencyStore that persists the records to a JSON file.
+// The results of the commands are serialized as JSON, once loaded from the file they are decoded as generic JSON values
+// (map[string]any, []any, float64, string, bool or nil).
+// Expired records are purged whenever the file is written.
+type FileIdempotencyStore struct {
+	sync.Mutex
+	path    string
+	records map[string]*IdempotencyRecord
+	clock   Clock
+}
+
+// NewFileIdempotencyStore instantiates the FileIdempotencyStore struct.
+// Previously persisted records are loaded from the file, if it exists.
+func NewFileIdempotencyStore(path string) (*FileIdempotencyStore, error) {
+	store := &FileIdempotencyStore{
+		path:    path,
+		records: make(map[string]*IdempotencyRecord),
+		clock:   SystemClock{},
+	}
+	if err := store.read(); err != nil {
+		return nil, err
+	}
+	return store, nil
+}
+
+// SetClock may optionally be used to provide the clock used to expire the records, usually the clock of the bus.
+// It defaults to SystemClock.
+func (store *FileIdempotencyStore) SetClock(clock Clock) {
+	store.Lock()
+	defer store.Unlock()
+	store.clock = clock
+}
+
+// Load returns the record with the provided key, or nil if it does not exist or is expired.
+func (store *FileIdempotencyStore) Load(key string) (*IdempotencyRecord, error) {
+	store.Lock()
+	defer store.Unlock()
+	rec, ok := store.records[key]
+	if !ok || rec.expired(store.clock.Now()) {
+		return nil, nil
+	}
+	return rec, nil
+}
+
+// Store adds the record to the store, replacing any record with the same key, and persists it.
+func (store *FileIdempotencyStore) Store(rec *IdempotencyRecord) error {
+	store.Lock()
+	defer store.Unlock()
+	now := store.clock.Now()
+	records := make(map[string]*IdempotencyRecord, len(store.records)+1)
+	for key, stored := range store.records {
+		if !stored.expired(now) {
+			records[key] = stored
+		}
+	}
+	records[rec.Key] = rec
+	if err := store.write(records); err != nil {
+		return err
+	}
+	store.records = records
+	return nil
+}
+
+//------Internal------//
+
+type idempotencyRecord struct {
+	Key        string          `json:"key"`
+	Identifier Identifier      `json:"identifier"`
+	Data       json.RawMessage `json:"data"`
+	StoredAt   time.Time       `json:"stored_at"`
+	ExpiresAt  time.Time       `json:"expires_at"`
+}
+
+func (store *FileIdempotencyStore) read() error {
+	data, err := os.ReadFile(store.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var records []idempotencyRecord
+	if err = json.Unmarshal(data, &records); err != nil {
+		return err
+	}
+	for _, rec := range records {
+		var result any
+		if err = json.Unmarshal(rec.Data, &result); err != nil {
+			return err
+		}
+		store.records[rec.Key] = &IdempotencyRecord{
+			Key:        rec.Key,
+			Identifier: rec.Identifier,
+			Data:       result,
+			StoredAt:   rec.StoredAt,
+			ExpiresAt:  rec.ExpiresAt,
+		}
+	}
+	return nil
+}
+
+func (store *FileIdempotencyStore) write(records map[string]*IdempotencyRecord) error {
+	persisted := make([]idempotencyRecord, 0, len(records))
+	for _, rec := range records {
+		result, err := json.Marshal(rec.Data)
+		if err != nil {
+			return err
+		}
+		persisted = append(persisted, idempotencyRecord{
+			Key:        rec.Key,
+			Identifier: rec.Identifier,
+			Data:       result,
+			StoredAt:   rec.StoredAt,
+			ExpiresAt:  rec.ExpiresAt,
+		})
+	}
+	data, err := json.Marshal(persisted)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(store.path, data)
+}
@@ -0,0 +1,36 @@
+package command
+
+// State represents the stage of the lifecycle the bus is currently in.
+type State uint32
+
+const (
+	// StateStopped is the initial state of the bus, commands are not accepted.
+	// The bus settings may only be adjusted in this state.
+	StateStopped State = iota
+	// StateStarting is the state of the bus while the OnStart hooks run and the workers are started.
+	StateStarting
+	// StateRunning is the state of the bus while it accepts and processes commands.
+	StateRunning
+	// StateStopping is the state of the bus while the queued commands are drained and the workers are stopped.
+	StateStopping
+)
+
+// String returns the name of the state.
+func (s State) String() string {
+	switch s {
+	case StateStopped:
+		return "stopped"
+	case StateStarting:
+		return "starting"
+	case StateRunning:
+		return "running"
+	case StateStopping:
+		return "stopping"
+	}
+	return "unknown"
+}
+
+// Hook is a function invoked by the bus on lifecycle transitions.
+// Hooks registered with OnStart may return an error to prevent the bus from starting.
+// Errors returned by the remaining hooks are passed on to the error handlers.
+type Hook func(bus *Bus) error
@@ -1,11 +1,42 @@
 package command
 
+import "context"
+
 // Next represents the type used to process the next step in the middlewares pipeline.
 type Next func(cmd Command) (any, error)
 
+// ContextNext represents the type used to process the next step in the middlewares pipeline, along with the context.
+type ContextNext func(ctx context.Context, cmd Command) (any, error)
+
 // Middleware must be implemented for a type to qualify as a command middleware.
 // Middlewares process commands before being provided to their respective command handler.
 // Middlewares may also execute logic after the command execution.
 type Middleware interface {
 	Handle(cmd Command, next Next) (any, error)
 }
+
+// ContextMiddleware may optionally be implemented by middlewares that require the context the command was issued with.
+// The bus will use HandleContext instead of Handle to process the commands through these middlewares.
+type ContextMiddleware interface {
+	Middleware
+	HandleContext(ctx context.Context, cmd Command, next ContextNext) (any, error)
+}
+
+// contextMiddleware adapts the context-free middlewares to the ContextMiddleware interface.
+// The context is carried over to the following steps of the pipeline.
+type contextMiddleware struct {
+	Middleware
+}
+
+func newContextMiddleware(mdl Middleware) ContextMiddleware {
+	if ctxMdl, ok := mdl.(ContextMiddleware); ok {
+		return ctxMdl
+	}
+	return &contextMiddleware{mdl}
+}
+
+func (mdl *contextMiddleware) HandleContext(ctx context.Context, cmd Command, next ContextNext) (any, error) {
+	return mdl.Handle(cmd, func(cmd Command) (any, error) {
+		return next(ctx, cmd)
+	})
+}
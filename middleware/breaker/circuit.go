@@ -0,0 +1,103 @@
+package breaker
+
+import (
+	"time"
+
+	"github.com/io-da/command"
+)
+
+// buckets is the number of intervals the window is divided in, so that old results expire gradually.
+const buckets = 10
+
+type bucket struct {
+	start    time.Time
+	requests int
+	failures int
+}
+
+// circuit tracks the state of the commands with the same identifier.
+// It is not safe for concurrent use, the middleware synchronizes the access.
+type circuit struct {
+	identifier command.Identifier
+	state      State
+	openedAt   time.Time
+	buckets    [buckets]bucket
+	probes     int
+	successes  int
+}
+
+// allow determines whether the command may proceed, returning the transition it caused (if any).
+func (c *circuit) allow(policy Policy, now time.Time) (bool, *Transition) {
+	var transition *Transition
+	if c.state == StateOpen {
+		if now.Sub(c.openedAt) < policy.OpenTimeout {
+			return false, nil
+		}
+		transition = c.transition(StateHalfOpen, now)
+	}
+	if c.state == StateHalfOpen {
+		if c.probes >= policy.HalfOpenRequests {
+			return false, transition
+		}
+		c.probes++
+	}
+	return true, transition
+}
+
+// record registers the outcome of a command, returning the transition it caused (if any).
+func (c *circuit) record(policy Policy, now time.Time, failed bool) *Transition {
+	switch c.state {
+	case StateHalfOpen:
+		if failed {
+			return c.transition(StateOpen, now)
+		}
+		if c.successes++; c.successes >= policy.HalfOpenRequests {
+			return c.transition(StateClosed, now)
+		}
+	case StateClosed:
+		b := c.bucket(policy, now)
+		b.requests++
+		if failed {
+			b.failures++
+		}
+		requests, failures := c.totals(policy, now)
+		if failed && requests >= policy.MinRequests && float64(failures)/float64(requests) >= policy.FailureRatio {
+			return c.transition(StateOpen, now)
+		}
+	}
+	return nil
+}
+
+func (c *circuit) transition(state State, now time.Time) *Transition {
+	transition := &Transition{Identifier: c.identifier, From: c.state, To: state}
+	c.state = state
+	c.probes = 0
+	c.successes = 0
+	switch state {
+	case StateOpen:
+		c.openedAt = now
+	case StateClosed:
+		c.buckets = [buckets]bucket{}
+	}
+	return transition
+}
+
+func (c *circuit) bucket(policy Policy, now time.Time) *bucket {
+	width := max(policy.Window/buckets, 1)
+	start := now.Truncate(width)
+	b := &c.buckets[(start.UnixNano()/int64(width))%buckets]
+	if !b.start.Equal(start) {
+		*b = bucket{start: start}
+	}
+	return b
+}
+
+func (c *circuit) totals(policy Policy, now time.Time) (requests int, failures int) {
+	for _, b := range c.buckets {
+		if now.Sub(b.start) < policy.Window {
+			requests += b.requests
+			failures += b.failures
+		}
+	}
+	return
+}
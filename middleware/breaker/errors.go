@@ -0,0 +1,19 @@
+package breaker
+
+import (
+	"context"
+	"errors"
+
+	"github.com/io-da/command"
+)
+
+// CircuitOpenError will be returned immediately for the commands whose circuit is open.
+const CircuitOpenError = command.BusError("breaker: the circuit is open")
+
+// Classifier determines whether a command that failed with the provided error counts as a failure of the circuit.
+type Classifier func(err error) bool
+
+// DefaultClassifier counts every error as a failure, except for canceled contexts and CircuitOpenError.
+func DefaultClassifier(err error) bool {
+	return !errors.Is(err, context.Canceled) && !errors.Is(err, CircuitOpenError)
+}
@@ -0,0 +1,170 @@
+package breaker
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/io-da/command"
+)
+
+// Policy describes when the circuit of a command opens and how it recovers.
+// The circuit opens once the ratio of failures within the window reaches FailureRatio, provided that at least
+// MinRequests were processed in the window. It remains open for OpenTimeout, after which HalfOpenRequests probe
+// commands are let through. The circuit closes if all of them succeed and opens again as soon as any of them fails.
+// Zero values default to a FailureRatio of 0.5, a MinRequests of 1, a Window of 1 minute, an OpenTimeout of 30 seconds
+// and 1 HalfOpenRequests. A nil Classifier defaults to DefaultClassifier.
+type Policy struct {
+	FailureRatio     float64
+	MinRequests      int
+	Window           time.Duration
+	OpenTimeout      time.Duration
+	HalfOpenRequests int
+	Classifier       Classifier
+}
+
+// Middleware rejects the commands immediately with CircuitOpenError while the circuit of their identifier is open.
+type Middleware struct {
+	sync.Mutex
+	policy    Policy
+	overrides map[command.Identifier]Policy
+	circuits  map[command.Identifier]*circuit
+	callbacks []StateChange
+	clock     command.Clock
+}
+
+// NewMiddleware instantiates the circuit breaker Middleware with the default policy for every command.
+func NewMiddleware(policy Policy) *Middleware {
+	return &Middleware{
+		policy:    withDefaults(policy),
+		overrides: make(map[command.Identifier]Policy),
+		circuits:  make(map[command.Identifier]*circuit),
+		clock:     command.SystemClock{},
+	}
+}
+
+// SetClock may optionally be used to provide the clock that times the windows and the timeouts, usually the clock of the bus.
+// It defaults to command.SystemClock.
+func (mdl *Middleware) SetClock(clock command.Clock) {
+	mdl.Lock()
+	mdl.clock = clock
+	mdl.Unlock()
+}
+
+// SetPolicy overrides the default policy for the commands with the provided identifiers.
+func (mdl *Middleware) SetPolicy(policy Policy, identifiers ...command.Identifier) {
+	mdl.Lock()
+	for _, identifier := range identifiers {
+		mdl.overrides[identifier] = withDefaults(policy)
+	}
+	mdl.Unlock()
+}
+
+// OnStateChange registers callbacks to be invoked whenever the circuit of a command changes state.
+// The callbacks are invoked synchronously by the command that caused the transition.
+func (mdl *Middleware) OnStateChange(callbacks ...StateChange) {
+	mdl.Lock()
+	mdl.callbacks = append(mdl.callbacks, callbacks...)
+	mdl.Unlock()
+}
+
+// State returns the current state of the circuit of the commands with the provided identifier.
+func (mdl *Middleware) State(identifier command.Identifier) State {
+	mdl.Lock()
+	defer mdl.Unlock()
+	c, ok := mdl.circuits[identifier]
+	if !ok {
+		return StateClosed
+	}
+	if c.state == StateOpen && mdl.clock.Now().Sub(c.openedAt) >= mdl.getPolicy(identifier).OpenTimeout {
+		// the circuit transitions once the next command arrives
+		return StateHalfOpen
+	}
+	return c.state
+}
+
+// Handle processes the command without context.
+func (mdl *Middleware) Handle(cmd command.Command, next command.Next) (any, error) {
+	return mdl.HandleContext(context.Background(), cmd, func(_ context.Context, cmd command.Command) (any, error) {
+		return next(cmd)
+	})
+}
+
+// HandleContext proceeds to the next step of the pipeline, unless the circuit of the command is open.
+func (mdl *Middleware) HandleContext(ctx context.Context, cmd command.Command, next command.ContextNext) (any, error) {
+	identifier := cmd.Identifier()
+	mdl.Lock()
+	policy := mdl.getPolicy(identifier)
+	allowed, transition := mdl.getCircuit(identifier).allow(policy, mdl.clock.Now())
+	mdl.Unlock()
+	mdl.notify(transition)
+	if !allowed {
+		return nil, CircuitOpenError
+	}
+
+	var err error
+	completed := false
+	defer func() {
+		// panics are recorded as failures, otherwise half-open probes would never be released
+		failed := !completed || (err != nil && policy.Classifier(err))
+		mdl.Lock()
+		transition := mdl.getCircuit(identifier).record(policy, mdl.clock.Now(), failed)
+		mdl.Unlock()
+		mdl.notify(transition)
+	}()
+	data, err := next(ctx, cmd)
+	completed = true
+	return data, err
+}
+
+//------Internal------//
+
+func (mdl *Middleware) getPolicy(identifier command.Identifier) Policy {
+	if policy, ok := mdl.overrides[identifier]; ok {
+		return policy
+	}
+	return mdl.policy
+}
+
+func (mdl *Middleware) getCircuit(identifier command.Identifier) *circuit {
+	c, ok := mdl.circuits[identifier]
+	if !ok {
+		c = &circuit{identifier: identifier}
+		mdl.circuits[identifier] = c
+	}
+	return c
+}
+
+func (mdl *Middleware) notify(transition *Transition) {
+	if transition == nil {
+		return
+	}
+	mdl.Lock()
+	callbacks := mdl.callbacks
+	mdl.Unlock()
+	for _, callback := range callbacks {
+		callback(*transition)
+	}
+}
+
+func withDefaults(policy Policy) Policy {
+	if policy.FailureRatio <= 0 {
+		policy.FailureRatio = 0.5
+	}
+	if policy.MinRequests < 1 {
+		policy.MinRequests = 1
+	}
+	if policy.Window <= 0 {
+		policy.Window = time.Minute
+	}
+	if policy.OpenTimeout <= 0 {
+		policy.OpenTimeout = 30 * time.Second
+	}
+	if policy.HalfOpenRequests < 1 {
+		policy.HalfOpenRequests = 1
+	}
+	if policy.Classifier == nil {
+		policy.Classifier = DefaultClassifier
+	}
+	return policy
+}
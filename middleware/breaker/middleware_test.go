@@ -0,0 +1,202 @@
+package breaker
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/io-da/command"
+	"github.com/io-da/command/clocktest"
+)
+
+const (
+	TestCommand1 command.Identifier = "TestCommand1"
+	TestCommand2 command.Identifier = "TestCommand2"
+)
+
+const commandFailedError = "command failed"
+
+type testCommand struct {
+	identifier command.Identifier
+}
+
+func (cmd *testCommand) Identifier() command.Identifier {
+	return cmd.identifier
+}
+
+type testToggleHandler struct {
+	handles   command.Identifier
+	failing   atomic.Bool
+	panicking atomic.Bool
+	handled   atomic.Int32
+}
+
+func (hdl *testToggleHandler) Handles() command.Identifier {
+	return hdl.handles
+}
+
+func (hdl *testToggleHandler) Handle(cmd command.Command) (any, error) {
+	hdl.handled.Add(1)
+	if hdl.panicking.Load() {
+		panic(commandFailedError)
+	}
+	if hdl.failing.Load() {
+		return nil, errors.New(commandFailedError)
+	}
+	return "ok", nil
+}
+
+type storeTransitions struct {
+	sync.Mutex
+	transitions []Transition
+}
+
+func (st *storeTransitions) store(transition Transition) {
+	st.Lock()
+	st.transitions = append(st.transitions, transition)
+	st.Unlock()
+}
+
+func TestMiddleware_CircuitBreaker(t *testing.T) {
+	bus := command.NewBus()
+	hdl := &testToggleHandler{handles: TestCommand1}
+	hdl2 := &testToggleHandler{handles: TestCommand2}
+	hdl.failing.Store(true)
+
+	clock := clocktest.NewFakeClock(time.Now())
+	mdl := NewMiddleware(Policy{FailureRatio: 0.5, MinRequests: 2, OpenTimeout: time.Second})
+	mdl.SetClock(clock)
+	transitions := &storeTransitions{}
+	mdl.OnStateChange(transitions.store)
+	bus.SetMiddlewares(mdl)
+	if err := bus.Initialize(hdl, hdl2); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	for i := 0; i < 2; i++ {
+		if _, err := bus.Handle(&testCommand{TestCommand1}); err == nil || err.Error() != commandFailedError {
+			t.Error("Expected the command to fail.")
+		}
+	}
+	if mdl.State(TestCommand1) != StateOpen {
+		t.Error("Expected the circuit to be open.")
+	}
+	if _, err := bus.Handle(&testCommand{TestCommand1}); err != CircuitOpenError {
+		t.Error("Expected CircuitOpenError error.")
+	} else if err.Error() != "breaker: the circuit is open" {
+		t.Error("Unexpected CircuitOpenError message.")
+	}
+	if hdl.handled.Load() != 2 {
+		t.Error("The handler should not be invoked while the circuit is open.")
+	}
+	if data, err := bus.Handle(&testCommand{TestCommand2}); err != nil || data != "ok" {
+		t.Error("The circuits of the remaining commands should not be affected.")
+	}
+
+	// a failed probe opens the circuit once again
+	clock.Advance(time.Second - time.Millisecond)
+	if mdl.State(TestCommand1) != StateOpen {
+		t.Error("Expected the circuit to remain open until the timeout elapses.")
+	}
+	clock.Advance(time.Millisecond)
+	if mdl.State(TestCommand1) != StateHalfOpen {
+		t.Error("Expected the circuit to be half-open.")
+	}
+	if _, err := bus.Handle(&testCommand{TestCommand1}); err == nil || err.Error() != commandFailedError {
+		t.Error("Expected the probe to fail.")
+	}
+	if mdl.State(TestCommand1) != StateOpen {
+		t.Error("Expected the circuit to be open.")
+	}
+
+	hdl.failing.Store(false)
+	clock.Advance(time.Second)
+	if data, err := bus.Handle(&testCommand{TestCommand1}); err != nil || data != "ok" {
+		t.Error("Expected the probe to succeed.")
+	}
+	if mdl.State(TestCommand1) != StateClosed {
+		t.Error("Expected the circuit to be closed.")
+	}
+
+	expected := []Transition{
+		{TestCommand1, StateClosed, StateOpen},
+		{TestCommand1, StateOpen, StateHalfOpen},
+		{TestCommand1, StateHalfOpen, StateOpen},
+		{TestCommand1, StateOpen, StateHalfOpen},
+		{TestCommand1, StateHalfOpen, StateClosed},
+	}
+	if len(transitions.transitions) != len(expected) {
+		t.Fatal("Unexpected number of transitions.")
+	}
+	for i, transition := range expected {
+		if transitions.transitions[i] != transition {
+			t.Errorf("Unexpected transition %v.", transitions.transitions[i])
+		}
+	}
+}
+
+func TestMiddleware_Panic(t *testing.T) {
+	bus := command.NewBus()
+	hdl := &testToggleHandler{handles: TestCommand1}
+	hdl.panicking.Store(true)
+
+	clock := clocktest.NewFakeClock(time.Now())
+	mdl := NewMiddleware(Policy{MinRequests: 1, OpenTimeout: time.Second})
+	mdl.SetClock(clock)
+	bus.SetMiddlewares(mdl)
+	if err := bus.Initialize(hdl); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	var panicErr *command.PanicError
+	if _, err := bus.Handle(&testCommand{TestCommand1}); !errors.As(err, &panicErr) {
+		t.Error("Expected PanicError error.")
+	}
+	if mdl.State(TestCommand1) != StateOpen {
+		t.Error("Expected the panic to open the circuit.")
+	}
+
+	// a panicking probe releases the half-open circuit
+	clock.Advance(time.Second)
+	if _, err := bus.Handle(&testCommand{TestCommand1}); !errors.As(err, &panicErr) {
+		t.Error("Expected PanicError error.")
+	}
+	if mdl.State(TestCommand1) != StateOpen {
+		t.Error("Expected the circuit to be open.")
+	}
+
+	hdl.panicking.Store(false)
+	clock.Advance(time.Second)
+	if data, err := bus.Handle(&testCommand{TestCommand1}); err != nil || data != "ok" {
+		t.Error("Expected the probe to succeed.")
+	}
+	if mdl.State(TestCommand1) != StateClosed {
+		t.Error("Expected the circuit to be closed.")
+	}
+}
+
+func TestMiddleware_FailureRatio(t *testing.T) {
+	bus := command.NewBus()
+	hdl := &testToggleHandler{handles: TestCommand1}
+
+	mdl := NewMiddleware(Policy{FailureRatio: 0.5, MinRequests: 4})
+	bus.SetMiddlewares(mdl)
+	if err := bus.Initialize(hdl); err != nil {
+		t.Fatal(err.Error())
+	}
+	for i := 0; i < 3; i++ {
+		_, _ = bus.Handle(&testCommand{TestCommand1})
+	}
+	hdl.failing.Store(true)
+	_, _ = bus.Handle(&testCommand{TestCommand1})
+	_, _ = bus.Handle(&testCommand{TestCommand1})
+	if mdl.State(TestCommand1) != StateClosed {
+		t.Error("Expected the circuit to remain closed below the failure ratio.")
+	}
+	_, _ = bus.Handle(&testCommand{TestCommand1})
+	if mdl.State(TestCommand1) != StateOpen {
+		t.Error("Expected the circuit to open once the failure ratio is reached.")
+	}
+}
@@ -0,0 +1,38 @@
+package breaker
+
+import "github.com/io-da/command"
+
+// State of the circuit of a command.
+type State int
+
+const (
+	// StateClosed lets the commands through while monitoring their failures.
+	StateClosed State = iota
+	// StateOpen rejects the commands immediately with CircuitOpenError.
+	StateOpen
+	// StateHalfOpen lets a limited number of probe commands through to determine whether to close the circuit again.
+	StateHalfOpen
+)
+
+// String returns the name of the state.
+func (s State) String() string {
+	switch s {
+	case StateClosed:
+		return "closed"
+	case StateOpen:
+		return "open"
+	case StateHalfOpen:
+		return "half-open"
+	}
+	return "unknown"
+}
+
+// Transition describes the change of state of the circuit of a command.
+type Transition struct {
+	Identifier command.Identifier
+	From       State
+	To         State
+}
+
+// StateChange is invoked whenever the circuit of a command changes state.
+type StateChange func(transition Transition)
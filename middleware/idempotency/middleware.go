@@ -0,0 +1,122 @@
+package idempotency
+
+import (
+	"context"
+	"runtime/debug"
+	"sync"
+	"time"
+
+	"github.com/io-da/command"
+)
+
+// Middleware processes idempotent commands only once per idempotency key.
+// Repeated commands return the stored result of the first one instead of reaching their handler.
+// Concurrent duplicates wait for the command in-flight and share its result.
+// Only successful results are stored, failed commands may be attempted once again.
+type Middleware struct {
+	sync.Mutex
+	bus      *command.Bus
+	store    command.IdempotencyStore
+	ttl      time.Duration
+	inFlight map[string]*call
+}
+
+type call struct {
+	done chan bool
+	data any
+	err  error
+}
+
+// NewMiddleware instantiates the idempotency Middleware, storing the results for the provided ttl.
+// A ttl lower or equal to 0 stores the results indefinitely.
+// Errors storing the results are reported to the error handlers of the bus, errors loading them fail the command.
+func NewMiddleware(bus *command.Bus, store command.IdempotencyStore, ttl time.Duration) *Middleware {
+	return &Middleware{
+		bus:      bus,
+		store:    store,
+		ttl:      ttl,
+		inFlight: make(map[string]*call),
+	}
+}
+
+// Handle processes the command without context.
+func (mdl *Middleware) Handle(cmd command.Command, next command.Next) (any, error) {
+	return mdl.HandleContext(context.Background(), cmd, func(_ context.Context, cmd command.Command) (any, error) {
+		return next(cmd)
+	})
+}
+
+// HandleContext proceeds to the next step of the pipeline, unless the command was already processed or is in-flight.
+// Waiting for a duplicate in-flight is interrupted if the context is done.
+func (mdl *Middleware) HandleContext(ctx context.Context, cmd command.Command, next command.ContextNext) (any, error) {
+	key, ok := command.IdempotencyKeyOf(cmd)
+	if !ok {
+		return next(ctx, cmd)
+	}
+	rec, err := mdl.store.Load(key)
+	if err != nil {
+		return nil, err
+	}
+	if rec != nil {
+		return rec.Data, nil
+	}
+
+	mdl.Lock()
+	if c, ok := mdl.inFlight[key]; ok {
+		mdl.Unlock()
+		select {
+		case <-c.done:
+			return c.data, c.err
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	c := &call{done: make(chan bool)}
+	mdl.inFlight[key] = c
+	mdl.Unlock()
+
+	defer func() {
+		// the duplicates in-flight must not mistake a panic for a success
+		r := recover()
+		if r != nil {
+			c.err = &command.PanicError{Value: r, Stack: debug.Stack()}
+		}
+		mdl.Lock()
+		delete(mdl.inFlight, key)
+		mdl.Unlock()
+		close(c.done)
+		if r != nil {
+			panic(r)
+		}
+	}()
+	// the result may have been stored by a duplicate that completed right after it was loaded
+	if rec, c.err = mdl.store.Load(key); c.err != nil || rec != nil {
+		if rec != nil {
+			c.data = rec.Data
+		}
+		return c.data, c.err
+	}
+	c.data, c.err = next(ctx, cmd)
+	if c.err == nil {
+		mdl.storeResult(ctx, cmd, key, c.data)
+	}
+	return c.data, c.err
+}
+
+//------Internal------//
+
+func (mdl *Middleware) storeResult(ctx context.Context, cmd command.Command, key string, data any) {
+	now := mdl.bus.Clock().Now()
+	rec := &command.IdempotencyRecord{
+		Key:        key,
+		Identifier: cmd.Identifier(),
+		Data:       data,
+		StoredAt:   now,
+	}
+	if mdl.ttl > 0 {
+		rec.ExpiresAt = now.Add(mdl.ttl)
+	}
+	if err := mdl.store.Store(rec); err != nil {
+		mdl.bus.ReportErrorContext(ctx, cmd, err)
+	}
+}
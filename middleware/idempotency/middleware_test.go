@@ -0,0 +1,237 @@
+package idempotency
+
+import (
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/io-da/command"
+	"github.com/io-da/command/clocktest"
+)
+
+const (
+	TestCommand1 command.Identifier = "TestCommand1"
+)
+
+type testCommand struct {
+	key string
+}
+
+func (cmd *testCommand) Identifier() command.Identifier {
+	return TestCommand1
+}
+
+func (cmd *testCommand) IdempotencyKey() string {
+	return cmd.key
+}
+
+type testHandler struct {
+	started chan bool
+	release chan bool
+	handled atomic.Int32
+}
+
+func (hdl *testHandler) Handles() command.Identifier {
+	return TestCommand1
+}
+
+func (hdl *testHandler) Handle(cmd command.Command) (any, error) {
+	handled := hdl.handled.Add(1)
+	hdl.started <- true
+	<-hdl.release
+	switch cmd.(*testCommand).key {
+	case "fail":
+		return nil, errors.New("command failed")
+	case "panic":
+		panic("command panicked")
+	}
+	return handled, nil
+}
+
+type testStore struct {
+	command.IdempotencyStore
+}
+
+func (store *testStore) Store(rec *command.IdempotencyRecord) error {
+	return errors.New("store failed")
+}
+
+type testUnavailableStore struct {
+	command.IdempotencyStore
+}
+
+func (store *testUnavailableStore) Load(key string) (*command.IdempotencyRecord, error) {
+	return nil, errors.New("store unavailable")
+}
+
+type testRacingStore struct {
+	command.IdempotencyStore
+	raced atomic.Bool
+}
+
+// Load stores a result right after loading the first record, as if a duplicate completed concurrently.
+func (store *testRacingStore) Load(key string) (*command.IdempotencyRecord, error) {
+	rec, err := store.IdempotencyStore.Load(key)
+	if store.raced.CompareAndSwap(false, true) {
+		_ = store.IdempotencyStore.Store(&command.IdempotencyRecord{Key: key, Identifier: TestCommand1, Data: "stored"})
+	}
+	return rec, err
+}
+
+type chanErrorsHandler struct {
+	errs chan error
+}
+
+func (hdl *chanErrorsHandler) Handle(cmd command.Command, err error) {
+	hdl.errs <- err
+}
+
+func TestMiddleware_Handle(t *testing.T) {
+	bus := command.NewBus()
+	bus.SetWorkerPoolSize(2)
+	hdl := &testHandler{started: make(chan bool, 10), release: make(chan bool)}
+	store := command.NewMemoryIdempotencyStore()
+	bus.SetMiddlewares(NewMiddleware(bus, store, time.Minute))
+	if err := bus.Initialize(hdl); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	results := make(chan any, 2)
+	for i := 0; i < 2; i++ {
+		go func() {
+			data, _ := bus.Handle(&testCommand{"foo"})
+			results <- data
+		}()
+	}
+	<-hdl.started
+	time.Sleep(10 * time.Millisecond)
+	close(hdl.release)
+	if first, second := <-results, <-results; first != int32(1) || second != int32(1) {
+		t.Error("Expected concurrent duplicates to share the result.")
+	}
+	if data, _ := bus.Handle(&testCommand{"foo"}); data != int32(1) {
+		t.Error("Expected the stored result to be returned.")
+	}
+	if rec, _ := store.Load("TestCommand1/foo"); rec == nil || rec.ExpiresAt.IsZero() {
+		t.Error("Expected the result to be stored with an expiration.")
+	}
+	if data, _ := bus.Handle(&testCommand{"bar"}); data != int32(2) {
+		t.Error("Expected commands with different keys to be handled.")
+	}
+
+	if _, err := bus.Handle(&testCommand{"fail"}); err == nil {
+		t.Error("Expected the command to fail.")
+	}
+	if _, err := bus.Handle(&testCommand{"fail"}); err == nil || hdl.handled.Load() != 4 {
+		t.Error("Expected failed commands to be handled again.")
+	}
+}
+
+func TestMiddleware_Expiration(t *testing.T) {
+	clock := clocktest.NewFakeClock(time.Now())
+	bus := command.NewBus()
+	bus.SetClock(clock)
+	hdl := &testHandler{started: make(chan bool, 10), release: make(chan bool)}
+	close(hdl.release)
+	store := command.NewMemoryIdempotencyStore()
+	store.SetClock(clock)
+	bus.SetMiddlewares(NewMiddleware(bus, store, time.Minute))
+	if err := bus.Initialize(hdl); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	if data, _ := bus.Handle(&testCommand{"foo"}); data != int32(1) {
+		t.Fatal("Expected the command to be handled.")
+	}
+	if rec, _ := store.Load("TestCommand1/foo"); rec == nil || !rec.StoredAt.Equal(clock.Now()) || !rec.ExpiresAt.Equal(clock.Now().Add(time.Minute)) {
+		t.Error("Expected the result to be stored according to the clock of the bus.")
+	}
+	clock.Advance(time.Minute - time.Millisecond)
+	if data, _ := bus.Handle(&testCommand{"foo"}); data != int32(1) {
+		t.Error("Expected the stored result to be returned.")
+	}
+	clock.Advance(time.Millisecond)
+	if data, _ := bus.Handle(&testCommand{"foo"}); data != int32(2) {
+		t.Error("Expected the command to be handled again once the result expired.")
+	}
+}
+
+func TestMiddleware_StoreError(t *testing.T) {
+	bus := command.NewBus()
+	hdl := &testHandler{started: make(chan bool, 10), release: make(chan bool)}
+	close(hdl.release)
+	errHdl := &chanErrorsHandler{errs: make(chan error, 1)}
+	bus.SetErrorHandlers(errHdl)
+	bus.SetMiddlewares(NewMiddleware(bus, &testStore{command.NewMemoryIdempotencyStore()}, 0))
+	if err := bus.Initialize(hdl); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	if data, err := bus.Handle(&testCommand{"foo"}); err != nil || data != int32(1) {
+		t.Error("Expected the result to be returned despite the store error.")
+	}
+	if err := <-errHdl.errs; err == nil || err.Error() != "command: TestCommand1 failed at reported: store failed" {
+		t.Errorf("Unexpected error %v.", err)
+	}
+
+	// without loading the stored results, it can not be known whether the command was already processed
+	bus = command.NewBus()
+	bus.SetMiddlewares(NewMiddleware(bus, &testUnavailableStore{command.NewMemoryIdempotencyStore()}, 0))
+	if err := bus.Initialize(hdl); err != nil {
+		t.Fatal(err.Error())
+	}
+	if _, err := bus.Handle(&testCommand{"foo"}); err == nil || err.Error() != "store unavailable" {
+		t.Errorf("Unexpected error %v.", err)
+	}
+	if hdl.handled.Load() != 1 {
+		t.Error("Expected the command not to be handled.")
+	}
+}
+
+func TestMiddleware_HandlePanic(t *testing.T) {
+	bus := command.NewBus()
+	bus.SetWorkerPoolSize(2)
+	hdl := &testHandler{started: make(chan bool, 10), release: make(chan bool)}
+	bus.SetMiddlewares(NewMiddleware(bus, command.NewMemoryIdempotencyStore(), 0))
+	if err := bus.Initialize(hdl); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	errs := make(chan error, 2)
+	for i := 0; i < 2; i++ {
+		go func() {
+			_, err := bus.Handle(&testCommand{"panic"})
+			errs <- err
+		}()
+	}
+	<-hdl.started
+	time.Sleep(10 * time.Millisecond)
+	close(hdl.release)
+	var panicErr *command.PanicError
+	for i := 0; i < 2; i++ {
+		if err := <-errs; !errors.As(err, &panicErr) {
+			t.Error("Expected the panic to be returned to the concurrent duplicates.")
+		}
+	}
+	if hdl.handled.Load() != 1 {
+		t.Error("Expected the command to be handled once.")
+	}
+}
+
+func TestMiddleware_HandleRacingDuplicate(t *testing.T) {
+	bus := command.NewBus()
+	hdl := &testHandler{started: make(chan bool, 10), release: make(chan bool)}
+	close(hdl.release)
+	bus.SetMiddlewares(NewMiddleware(bus, &testRacingStore{IdempotencyStore: command.NewMemoryIdempotencyStore()}, 0))
+	if err := bus.Initialize(hdl); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	if data, err := bus.Handle(&testCommand{"foo"}); err != nil || data != "stored" {
+		t.Error("Expected the result stored concurrently to be returned.")
+	}
+	if hdl.handled.Load() != 0 {
+		t.Error("Expected the command not to be handled again.")
+	}
+}
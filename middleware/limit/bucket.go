@@ -0,0 +1,52 @@
+package limit
+
+import (
+	"sync"
+	"time"
+)
+
+// bucket is a token bucket refilled at a constant rate, up to its burst.
+// Tokens may be reserved ahead of time, in which case the bucket goes negative and the following reservations wait longer.
+type bucket struct {
+	sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newBucket(rate float64, burst int, now time.Time) *bucket {
+	return &bucket{
+		rate:   rate,
+		burst:  float64(burst),
+		tokens: float64(burst),
+		last:   now,
+	}
+}
+
+// reserve takes a token, returning how long to wait for it to be available.
+// If wait is false, the token is only taken if it is available immediately.
+func (b *bucket) reserve(now time.Time, wait bool) (time.Duration, bool) {
+	b.Lock()
+	defer b.Unlock()
+	// the clock may have been replaced since the last reservation, time going backwards refills nothing
+	elapsed := max(0, now.Sub(b.last))
+	b.tokens = min(b.burst, b.tokens+elapsed.Seconds()*b.rate)
+	b.last = now
+	if b.tokens >= 1 {
+		b.tokens--
+		return 0, true
+	}
+	if !wait {
+		return 0, false
+	}
+	b.tokens--
+	return time.Duration(-b.tokens / b.rate * float64(time.Second)), true
+}
+
+// cancel returns a reserved token that was not used.
+func (b *bucket) cancel() {
+	b.Lock()
+	b.tokens = min(b.burst, b.tokens+1)
+	b.Unlock()
+}
@@ -0,0 +1,28 @@
+package limit
+
+import (
+	"fmt"
+
+	"github.com/io-da/command"
+)
+
+// Kind identifies the limit that was exceeded.
+type Kind string
+
+const (
+	// RateLimit is the kind of the limit on how often the commands are processed.
+	RateLimit Kind = "rate"
+	// ConcurrencyLimit is the kind of the limit on how many commands are processed concurrently.
+	ConcurrencyLimit Kind = "concurrency"
+)
+
+// LimitExceededError will be returned when a command is rejected for exceeding a limit with ModeReject.
+type LimitExceededError struct {
+	Identifier command.Identifier
+	Kind       Kind
+}
+
+// Error returns the string message of the error.
+func (e *LimitExceededError) Error() string {
+	return fmt.Sprintf("limit: %s limit of %s exceeded", e.Kind, e.Identifier)
+}
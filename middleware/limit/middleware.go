@@ -0,0 +1,181 @@
+package limit
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/io-da/command"
+)
+
+// Mode determines what happens to the commands exceeding a limit.
+type Mode int
+
+const (
+	// ModeWait makes the commands wait until they are within the limit, or until their context is done.
+	ModeWait Mode = iota
+	// ModeReject rejects the commands immediately with a *LimitExceededError.
+	ModeReject
+)
+
+// Limit describes how often and how concurrently the commands may be processed.
+// Rate is the number of commands per second, with bursts of up to Burst commands (defaulting to the rate rounded up).
+// Concurrency is the number of commands processed at the same time.
+// Zero values disable the respective limit.
+type Limit struct {
+	Rate        float64
+	Burst       int
+	Concurrency int
+	Mode        Mode
+}
+
+// Middleware limits the processing of the commands according to the limit of their identifier.
+// Commands without a limit are not affected.
+type Middleware struct {
+	sync.RWMutex
+	limiters map[command.Identifier]*limiter
+	clock    command.Clock
+}
+
+type limiter struct {
+	identifier command.Identifier
+	mode       Mode
+	bucket     *bucket
+	semaphore  chan bool
+}
+
+// NewMiddleware instantiates the limit Middleware.
+func NewMiddleware() *Middleware {
+	return &Middleware{
+		limiters: make(map[command.Identifier]*limiter),
+		clock:    command.SystemClock{},
+	}
+}
+
+// SetClock may optionally be used to provide the clock that refills the rate limits, usually the clock of the bus.
+// It defaults to command.SystemClock.
+func (mdl *Middleware) SetClock(clock command.Clock) {
+	mdl.Lock()
+	mdl.clock = clock
+	mdl.Unlock()
+}
+
+// SetLimit sets the limit of the commands with the provided identifiers.
+// Each identifier is limited separately.
+func (mdl *Middleware) SetLimit(limit Limit, identifiers ...command.Identifier) {
+	mdl.Lock()
+	for _, identifier := range identifiers {
+		mdl.limiters[identifier] = newLimiter(identifier, limit, mdl.clock.Now())
+	}
+	mdl.Unlock()
+}
+
+// Handle processes the command without context.
+func (mdl *Middleware) Handle(cmd command.Command, next command.Next) (any, error) {
+	return mdl.HandleContext(context.Background(), cmd, func(_ context.Context, cmd command.Command) (any, error) {
+		return next(cmd)
+	})
+}
+
+// HandleContext proceeds to the next step of the pipeline once the command is within its limits.
+// Waiting is interrupted if the context is done.
+func (mdl *Middleware) HandleContext(ctx context.Context, cmd command.Command, next command.ContextNext) (any, error) {
+	mdl.RLock()
+	lim, ok := mdl.limiters[cmd.Identifier()]
+	clock := mdl.clock
+	mdl.RUnlock()
+	if !ok {
+		return next(ctx, cmd)
+	}
+	if err := lim.take(ctx, clock); err != nil {
+		return nil, err
+	}
+	if err := lim.acquire(ctx); err != nil {
+		// the command was not processed, so it does not count towards the rate limit
+		lim.refund()
+		return nil, err
+	}
+	defer lim.release()
+	return next(ctx, cmd)
+}
+
+//------Internal------//
+
+func newLimiter(identifier command.Identifier, limit Limit, now time.Time) *limiter {
+	lim := &limiter{
+		identifier: identifier,
+		mode:       limit.Mode,
+	}
+	if limit.Rate > 0 {
+		burst := limit.Burst
+		if burst < 1 {
+			burst = int(math.Ceil(limit.Rate))
+		}
+		lim.bucket = newBucket(limit.Rate, burst, now)
+	}
+	if limit.Concurrency > 0 {
+		lim.semaphore = make(chan bool, limit.Concurrency)
+	}
+	return lim
+}
+
+// take waits for a token of the rate limit.
+func (lim *limiter) take(ctx context.Context, clock command.Clock) error {
+	if lim.bucket == nil {
+		return nil
+	}
+	delay, ok := lim.bucket.reserve(clock.Now(), lim.mode == ModeWait)
+	if !ok {
+		return &LimitExceededError{Identifier: lim.identifier, Kind: RateLimit}
+	}
+	if delay <= 0 {
+		return nil
+	}
+	elapsed := make(chan bool)
+	stop := clock.AfterFunc(delay, func() {
+		close(elapsed)
+	})
+	defer stop()
+	select {
+	case <-elapsed:
+		return nil
+	case <-ctx.Done():
+		lim.bucket.cancel()
+		return ctx.Err()
+	}
+}
+
+// refund returns the token taken for a command that was not processed.
+func (lim *limiter) refund() {
+	if lim.bucket != nil {
+		lim.bucket.cancel()
+	}
+}
+
+// acquire waits for a slot of the concurrency limit.
+func (lim *limiter) acquire(ctx context.Context) error {
+	if lim.semaphore == nil {
+		return nil
+	}
+	if lim.mode == ModeReject {
+		select {
+		case lim.semaphore <- true:
+			return nil
+		default:
+			return &LimitExceededError{Identifier: lim.identifier, Kind: ConcurrencyLimit}
+		}
+	}
+	select {
+	case lim.semaphore <- true:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (lim *limiter) release() {
+	if lim.semaphore != nil {
+		<-lim.semaphore
+	}
+}
@@ -0,0 +1,230 @@
+package limit
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/io-da/command"
+	"github.com/io-da/command/clocktest"
+)
+
+const (
+	TestCommand1 command.Identifier = "TestCommand1"
+	TestCommand2 command.Identifier = "TestCommand2"
+)
+
+type testCommand struct {
+	identifier command.Identifier
+}
+
+func (cmd *testCommand) Identifier() command.Identifier {
+	return cmd.identifier
+}
+
+type testConcurrentHandler struct {
+	handles    command.Identifier
+	release    chan bool
+	running    atomic.Int32
+	maxRunning atomic.Int32
+}
+
+func (hdl *testConcurrentHandler) Handles() command.Identifier {
+	return hdl.handles
+}
+
+func (hdl *testConcurrentHandler) Handle(cmd command.Command) (any, error) {
+	running := hdl.running.Add(1)
+	defer hdl.running.Add(-1)
+	for {
+		maxRunning := hdl.maxRunning.Load()
+		if running <= maxRunning || hdl.maxRunning.CompareAndSwap(maxRunning, running) {
+			break
+		}
+	}
+	<-hdl.release
+	return "ok", nil
+}
+
+type testHandler struct {
+	handles command.Identifier
+}
+
+func (hdl *testHandler) Handles() command.Identifier {
+	return hdl.handles
+}
+
+func (hdl *testHandler) Handle(cmd command.Command) (any, error) {
+	return "ok", nil
+}
+
+func TestMiddleware_ConcurrencyLimit(t *testing.T) {
+	bus := command.NewBus()
+	bus.SetWorkerPoolSize(8)
+	hdl := &testConcurrentHandler{handles: TestCommand1, release: make(chan bool)}
+	hdl2 := &testConcurrentHandler{handles: TestCommand2, release: make(chan bool)}
+
+	mdl := NewMiddleware()
+	mdl.SetLimit(Limit{Concurrency: 2}, TestCommand1)
+	mdl.SetLimit(Limit{Concurrency: 1, Mode: ModeReject}, TestCommand2)
+	bus.SetMiddlewares(mdl)
+	if err := bus.Initialize(hdl, hdl2); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	asl := command.NewAsyncList()
+	for i := 0; i < 6; i++ {
+		as, err := bus.HandleAsync(&testCommand{TestCommand1})
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		asl.Push(as)
+	}
+	as, _ := bus.HandleAsync(&testCommand{TestCommand2})
+	for hdl2.running.Load() != 1 {
+		time.Sleep(time.Millisecond)
+	}
+	var limitErr *LimitExceededError
+	if _, err := bus.Handle(&testCommand{TestCommand2}); !errors.As(err, &limitErr) || limitErr.Kind != ConcurrencyLimit {
+		t.Error("Expected the concurrency LimitExceededError error.")
+	} else if err.Error() != "limit: concurrency limit of TestCommand2 exceeded" {
+		t.Error("Unexpected LimitExceededError message.")
+	}
+	close(hdl2.release)
+	if _, err := as.Await(); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	close(hdl.release)
+	if _, err := asl.Await(); err != nil {
+		t.Fatal(err.Error())
+	}
+	if hdl.maxRunning.Load() != 2 {
+		t.Error("Expected at most 2 commands to be processed concurrently.")
+	}
+}
+
+func TestMiddleware_RateLimit(t *testing.T) {
+	bus := command.NewBus()
+	mdl := NewMiddleware()
+	mdl.SetLimit(Limit{Rate: 100, Burst: 1}, TestCommand1)
+	mdl.SetLimit(Limit{Rate: 1, Burst: 2, Mode: ModeReject}, TestCommand2)
+	bus.SetMiddlewares(mdl)
+	if err := bus.Initialize(&testHandler{TestCommand1}, &testHandler{TestCommand2}); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	start := time.Now()
+	for i := 0; i < 5; i++ {
+		if _, err := bus.Handle(&testCommand{TestCommand1}); err != nil {
+			t.Fatal(err.Error())
+		}
+	}
+	if time.Since(start) < 35*time.Millisecond {
+		t.Error("Expected the commands to wait for the rate limit.")
+	}
+
+	for i := 0; i < 2; i++ {
+		if _, err := bus.Handle(&testCommand{TestCommand2}); err != nil {
+			t.Fatal(err.Error())
+		}
+	}
+	var limitErr *LimitExceededError
+	if _, err := bus.Handle(&testCommand{TestCommand2}); !errors.As(err, &limitErr) || limitErr.Kind != RateLimit {
+		t.Error("Expected the rate LimitExceededError error.")
+	}
+
+	mdl.SetLimit(Limit{Rate: 1, Burst: 1}, TestCommand1)
+	if _, err := bus.Handle(&testCommand{TestCommand1}); err != nil {
+		t.Fatal(err.Error())
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := bus.HandleContext(ctx, &testCommand{TestCommand1}); err != context.DeadlineExceeded {
+		t.Error("Expected context.DeadlineExceeded error.")
+	}
+}
+
+func TestMiddleware_CombinedLimits(t *testing.T) {
+	bus := command.NewBus()
+	hdl := &testConcurrentHandler{handles: TestCommand1, release: make(chan bool)}
+	mdl := NewMiddleware()
+	mdl.SetLimit(Limit{Rate: 1, Burst: 2, Concurrency: 1, Mode: ModeReject}, TestCommand1)
+	bus.SetMiddlewares(mdl)
+	if err := bus.Initialize(hdl); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	as, _ := bus.HandleAsync(&testCommand{TestCommand1})
+	for hdl.running.Load() != 1 {
+		time.Sleep(time.Millisecond)
+	}
+	var limitErr *LimitExceededError
+	if _, err := bus.Handle(&testCommand{TestCommand1}); !errors.As(err, &limitErr) || limitErr.Kind != ConcurrencyLimit {
+		t.Error("Expected the concurrency LimitExceededError error.")
+	}
+	hdl.release <- true
+	if _, err := as.Await(); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	// the rejected command did not consume the rate limit
+	go func() {
+		hdl.release <- true
+	}()
+	if _, err := bus.Handle(&testCommand{TestCommand1}); err != nil {
+		t.Error("Expected the command to be within the rate limit.")
+	}
+}
+
+func TestMiddleware_Clock(t *testing.T) {
+	clock := clocktest.NewFakeClock(time.Now())
+	bus := command.NewBus()
+	mdl := NewMiddleware()
+	mdl.SetClock(clock)
+	mdl.SetLimit(Limit{Rate: 1, Burst: 1}, TestCommand1)
+	mdl.SetLimit(Limit{Rate: 1, Burst: 1, Mode: ModeReject}, TestCommand2)
+	bus.SetMiddlewares(mdl)
+	if err := bus.Initialize(&testHandler{TestCommand1}, &testHandler{TestCommand2}); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	if _, err := bus.Handle(&testCommand{TestCommand2}); err != nil {
+		t.Fatal(err.Error())
+	}
+	var limitErr *LimitExceededError
+	if _, err := bus.Handle(&testCommand{TestCommand2}); !errors.As(err, &limitErr) {
+		t.Error("Expected the rate LimitExceededError error.")
+	}
+	clock.Advance(time.Second)
+	if _, err := bus.Handle(&testCommand{TestCommand2}); err != nil {
+		t.Error("Expected the rate limit to be refilled by the clock.")
+	}
+
+	if _, err := bus.Handle(&testCommand{TestCommand1}); err != nil {
+		t.Fatal(err.Error())
+	}
+	started := clock.Now()
+	handled := make(chan error)
+	go func() {
+		_, err := bus.Handle(&testCommand{TestCommand1})
+		handled <- err
+	}()
+	for {
+		select {
+		case err := <-handled:
+			if err != nil {
+				t.Error(err.Error())
+			}
+			if clock.Now().Sub(started) < time.Second {
+				t.Error("Expected the command to wait for the rate limit.")
+			}
+			return
+		case <-time.After(time.Millisecond):
+			// the command waits on a timer of the clock, which only fires once advanced
+			clock.Advance(100 * time.Millisecond)
+		}
+	}
+}
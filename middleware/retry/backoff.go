@@ -0,0 +1,50 @@
+package retry
+
+import (
+	"math/rand"
+	"time"
+)
+
+// Backoff determines the delay before the following attempt.
+// The attempt provided is the number of the attempt that just failed (starting at 1),
+// and previous is the delay applied before that attempt (zero for the first one).
+type Backoff func(attempt int, previous time.Duration) time.Duration
+
+// Constant produces the same delay between every attempt.
+func Constant(d time.Duration) Backoff {
+	return func(int, time.Duration) time.Duration {
+		return d
+	}
+}
+
+// Exponential doubles the delay with every attempt, starting at base and capped at max.
+func Exponential(base, max time.Duration) Backoff {
+	return func(attempt int, _ time.Duration) time.Duration {
+		d := base
+		for i := 1; i < attempt && d < max; i++ {
+			d *= 2
+		}
+		if d > max {
+			return max
+		}
+		return d
+	}
+}
+
+// DecorrelatedJitter produces random delays between base and three times the previous delay, capped at max.
+// This strategy spreads the retries of concurrent commands failing at the same time.
+func DecorrelatedJitter(base, max time.Duration) Backoff {
+	return func(_ int, previous time.Duration) time.Duration {
+		if previous < base {
+			previous = base
+		}
+		upper := previous * 3
+		if upper > max {
+			upper = max
+		}
+		if upper <= base {
+			return upper
+		}
+		return base + time.Duration(rand.Int63n(int64(upper-base)))
+	}
+}
@@ -0,0 +1,44 @@
+package retry
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/io-da/command"
+)
+
+// Retryable may be implemented by errors to explicitly determine whether a failed command should be retried.
+type Retryable interface {
+	Retryable() bool
+}
+
+// Classifier determines whether a command that failed with the provided error should be retried.
+type Classifier func(err error) bool
+
+// DefaultClassifier retries every error except for context errors.
+// Errors implementing Retryable (anywhere in their chain) decide for themselves.
+func DefaultClassifier(err error) bool {
+	var retryable Retryable
+	if errors.As(err, &retryable) {
+		return retryable.Retryable()
+	}
+	return !errors.Is(err, context.Canceled) && !errors.Is(err, context.DeadlineExceeded)
+}
+
+// AttemptError is reported to the error handlers of the bus for every failed attempt.
+type AttemptError struct {
+	Identifier command.Identifier
+	Attempt    int
+	Err        error
+}
+
+// Error returns the string message of the error.
+func (e *AttemptError) Error() string {
+	return fmt.Sprintf("retry: attempt %d of %s failed: %v", e.Attempt, e.Identifier, e.Err)
+}
+
+// Unwrap returns the error of the failed attempt.
+func (e *AttemptError) Unwrap() error {
+	return e.Err
+}
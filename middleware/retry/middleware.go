@@ -0,0 +1,111 @@
+package retry
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/io-da/command"
+)
+
+// Policy describes how the commands are retried.
+// MaxAttempts includes the first attempt, values lower than 1 disable the retries.
+// A nil Backoff retries immediately and a nil Classifier defaults to DefaultClassifier.
+type Policy struct {
+	MaxAttempts int
+	Backoff     Backoff
+	Classifier  Classifier
+}
+
+// Middleware retries the remaining steps of the pipeline whenever they fail with a retryable error.
+// Every failed attempt is reported to the error handlers of the bus as an *AttemptError.
+type Middleware struct {
+	sync.RWMutex
+	bus       *command.Bus
+	policy    Policy
+	overrides map[command.Identifier]Policy
+}
+
+// NewMiddleware instantiates the retry Middleware with the default policy for every command.
+func NewMiddleware(bus *command.Bus, policy Policy) *Middleware {
+	return &Middleware{
+		bus:       bus,
+		policy:    policy,
+		overrides: make(map[command.Identifier]Policy),
+	}
+}
+
+// SetPolicy overrides the default policy for the commands with the provided identifiers.
+func (mdl *Middleware) SetPolicy(policy Policy, identifiers ...command.Identifier) {
+	mdl.Lock()
+	for _, identifier := range identifiers {
+		mdl.overrides[identifier] = policy
+	}
+	mdl.Unlock()
+}
+
+// Handle processes the command without context.
+func (mdl *Middleware) Handle(cmd command.Command, next command.Next) (any, error) {
+	return mdl.HandleContext(context.Background(), cmd, func(_ context.Context, cmd command.Command) (any, error) {
+		return next(cmd)
+	})
+}
+
+// HandleContext attempts the next step of the pipeline according to the policy of the command.
+// Waiting for the following attempt is interrupted if the context is done.
+func (mdl *Middleware) HandleContext(ctx context.Context, cmd command.Command, next command.ContextNext) (any, error) {
+	policy := mdl.getPolicy(cmd.Identifier())
+	var delay time.Duration
+	for attempt := 1; ; attempt++ {
+		data, err := next(ctx, cmd)
+		if err == nil {
+			return data, nil
+		}
+		mdl.bus.ReportErrorContext(ctx, cmd, &AttemptError{
+			Identifier: cmd.Identifier(),
+			Attempt:    attempt,
+			Err:        err,
+		})
+		if attempt >= policy.MaxAttempts || !policy.Classifier(err) {
+			return nil, err
+		}
+		if policy.Backoff != nil {
+			delay = policy.Backoff(attempt, delay)
+		}
+		if err = wait(ctx, mdl.bus.Clock(), delay); err != nil {
+			return nil, err
+		}
+	}
+}
+
+//------Internal------//
+
+func (mdl *Middleware) getPolicy(identifier command.Identifier) Policy {
+	mdl.RLock()
+	policy, ok := mdl.overrides[identifier]
+	mdl.RUnlock()
+	if !ok {
+		policy = mdl.policy
+	}
+	if policy.Classifier == nil {
+		policy.Classifier = DefaultClassifier
+	}
+	return policy
+}
+
+func wait(ctx context.Context, clock command.Clock, d time.Duration) error {
+	if d <= 0 {
+		return ctx.Err()
+	}
+	elapsed := make(chan bool)
+	stop := clock.AfterFunc(d, func() {
+		close(elapsed)
+	})
+	defer stop()
+	select {
+	case <-elapsed:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,192 @@
+package retry
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/io-da/command"
+	"github.com/io-da/command/clocktest"
+)
+
+const (
+	TestCommand1 command.Identifier = "TestCommand1"
+	TestCommand2 command.Identifier = "TestCommand2"
+)
+
+const commandFailedError = "command failed"
+
+type testCommand struct {
+	identifier command.Identifier
+}
+
+func (cmd *testCommand) Identifier() command.Identifier {
+	return cmd.identifier
+}
+
+type testFlakyHandler struct {
+	sync.Mutex
+	handles  command.Identifier
+	failures int
+	attempts int
+	err      error
+}
+
+func (hdl *testFlakyHandler) Handles() command.Identifier {
+	return hdl.handles
+}
+
+func (hdl *testFlakyHandler) Handle(cmd command.Command) (any, error) {
+	hdl.Lock()
+	defer hdl.Unlock()
+	hdl.attempts++
+	if hdl.attempts <= hdl.failures {
+		return nil, hdl.err
+	}
+	return "ok", nil
+}
+
+type testNonRetryableError struct{}
+
+func (testNonRetryableError) Error() string {
+	return "non retryable"
+}
+
+func (testNonRetryableError) Retryable() bool {
+	return false
+}
+
+type storeAttemptsHandler struct {
+	sync.Mutex
+	attempts []int
+}
+
+func (hdl *storeAttemptsHandler) Handle(cmd command.Command, err error) {
+	var attemptErr *AttemptError
+	if errors.As(err, &attemptErr) {
+		hdl.Lock()
+		hdl.attempts = append(hdl.attempts, attemptErr.Attempt)
+		hdl.Unlock()
+	}
+}
+
+func TestMiddleware_Retry(t *testing.T) {
+	bus := command.NewBus()
+	hdl := &testFlakyHandler{handles: TestCommand1, failures: 2, err: errors.New(commandFailedError)}
+	hdl2 := &testFlakyHandler{handles: TestCommand2, failures: 2, err: errors.New(commandFailedError)}
+	errHdl := &storeAttemptsHandler{}
+	bus.SetErrorHandlers(errHdl)
+
+	mdl := NewMiddleware(bus, Policy{MaxAttempts: 3, Backoff: Constant(time.Millisecond)})
+	mdl.SetPolicy(Policy{MaxAttempts: 2}, TestCommand2)
+	bus.SetMiddlewares(mdl)
+	if err := bus.Initialize(hdl, hdl2); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	data, err := bus.Handle(&testCommand{TestCommand1})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if data != "ok" || hdl.attempts != 3 {
+		t.Error("The command should succeed on the third attempt.")
+	}
+	if len(errHdl.attempts) != 2 || errHdl.attempts[0] != 1 || errHdl.attempts[1] != 2 {
+		t.Error("Unexpected reported attempts.")
+	}
+
+	if _, err = bus.Handle(&testCommand{TestCommand2}); err == nil || err.Error() != commandFailedError {
+		t.Error("Expected the overridden policy to give up after 2 attempts.")
+	}
+	if hdl2.attempts != 2 {
+		t.Error("Unexpected number of attempts.")
+	}
+}
+
+func TestMiddleware_NonRetryable(t *testing.T) {
+	bus := command.NewBus()
+	hdl := &testFlakyHandler{handles: TestCommand1, failures: 2, err: testNonRetryableError{}}
+
+	bus.SetMiddlewares(NewMiddleware(bus, Policy{MaxAttempts: 3}))
+	if err := bus.Initialize(hdl); err != nil {
+		t.Fatal(err.Error())
+	}
+	if _, err := bus.Handle(&testCommand{TestCommand1}); err == nil {
+		t.Error("Expected the non retryable error.")
+	}
+	if hdl.attempts != 1 {
+		t.Error("Non retryable errors should not be retried.")
+	}
+}
+
+func TestMiddleware_ContextDone(t *testing.T) {
+	bus := command.NewBus()
+	hdl := &testFlakyHandler{handles: TestCommand1, failures: 5, err: errors.New(commandFailedError)}
+
+	bus.SetMiddlewares(NewMiddleware(bus, Policy{MaxAttempts: 5, Backoff: Constant(time.Hour)}))
+	if err := bus.Initialize(hdl); err != nil {
+		t.Fatal(err.Error())
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*10)
+	defer cancel()
+	if _, err := bus.HandleContext(ctx, &testCommand{TestCommand1}); err != context.DeadlineExceeded {
+		t.Error("Expected context.DeadlineExceeded error.")
+	}
+	if hdl.attempts != 1 {
+		t.Error("Unexpected number of attempts.")
+	}
+}
+
+func TestMiddleware_Clock(t *testing.T) {
+	bus := command.NewBus()
+	clock := clocktest.NewFakeClock(time.Now())
+	bus.SetClock(clock)
+	hdl := &testFlakyHandler{handles: TestCommand1, failures: 1, err: errors.New(commandFailedError)}
+
+	bus.SetMiddlewares(NewMiddleware(bus, Policy{MaxAttempts: 2, Backoff: Constant(time.Hour)}))
+	if err := bus.Initialize(hdl); err != nil {
+		t.Fatal(err.Error())
+	}
+	start := clock.Now()
+	as, _ := bus.HandleAsync(&testCommand{TestCommand1})
+	done := make(chan bool)
+	go func() {
+		if data, err := as.Await(); err != nil || data != "ok" {
+			t.Error("The command should succeed once the backoff elapses.")
+		}
+		close(done)
+	}()
+	for {
+		select {
+		case <-done:
+			if clock.Now().Sub(start) < time.Hour {
+				t.Error("The command should not be retried before the backoff elapses.")
+			}
+			return
+		case <-time.After(time.Millisecond):
+			// the backoff waits on a timer of the clock, which only fires once advanced
+			clock.Advance(time.Minute)
+		}
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	exp := Exponential(time.Millisecond, time.Millisecond*5)
+	for attempt, expected := range []time.Duration{time.Millisecond, time.Millisecond * 2, time.Millisecond * 4, time.Millisecond * 5} {
+		if d := exp(attempt+1, 0); d != expected {
+			t.Errorf("Expected exponential backoff %v, got %v", expected, d)
+		}
+	}
+
+	jitter := DecorrelatedJitter(time.Millisecond, time.Second)
+	var previous time.Duration
+	for attempt := 1; attempt < 100; attempt++ {
+		d := jitter(attempt, previous)
+		if d < time.Millisecond || d > time.Second || (previous > 0 && d > previous*3) {
+			t.Fatalf("Unexpected decorrelated jitter backoff %v", d)
+		}
+		previous = d
+	}
+}
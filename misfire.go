@@ -0,0 +1,58 @@
+package command
+
+import "time"
+
+// MisfireStrategy determines how the missed occurrences of scheduled commands are handled.
+// An occurrence is missed when the scheduler is late to issue it, e.g. because the process was paused or stopped.
+type MisfireStrategy int
+
+const (
+	// MisfireFireOnce issues the command once, regardless of how many occurrences were missed.
+	MisfireFireOnce MisfireStrategy = iota
+	// MisfireCatchUp issues the command once for every missed occurrence.
+	MisfireCatchUp
+	// MisfireSkip skips the missed occurrences, the command is only issued on its next occurrence.
+	MisfireSkip
+	// MisfireGrace issues the command once if the last missed occurrence is within the grace period, skipping it otherwise.
+	MisfireGrace
+)
+
+// MisfirePolicy describes how the missed occurrences of scheduled commands are handled.
+// Grace is required by MisfireGrace.
+type MisfirePolicy struct {
+	Strategy MisfireStrategy
+	Grace    time.Duration
+}
+
+// Misfirer may optionally be implemented by scheduled commands to determine their own misfire policy.
+// It takes precedence over the misfire policy set in the bus.
+type Misfirer interface {
+	MisfirePolicy() MisfirePolicy
+}
+
+//------Internal------//
+
+// misfireThreshold is the delay after which an occurrence is considered missed.
+const misfireThreshold = time.Second
+
+// fires determines how many times the command is issued for the due occurrences, given the delay of the last one.
+func (policy MisfirePolicy) fires(due int, delay time.Duration) int {
+	if due == 0 {
+		return 0
+	}
+	switch policy.Strategy {
+	case MisfireCatchUp:
+		return due
+	case MisfireSkip:
+		if delay <= misfireThreshold {
+			return 1
+		}
+		return 0
+	case MisfireGrace:
+		if delay <= max(policy.Grace, misfireThreshold) {
+			return 1
+		}
+		return 0
+	}
+	return 1
+}
@@ -0,0 +1,81 @@
+package command
+
+import (
+	"sync"
+	"time"
+)
+
+// OverflowStrategy determines how async commands are handled when the queue is full.
+type OverflowStrategy int
+
+const (
+	// OverflowBlock blocks until there is room in the queue.
+	OverflowBlock OverflowStrategy = iota
+	// OverflowBlockTimeout blocks until there is room in the queue, failing with QueueFullError once the timeout elapses.
+	OverflowBlockTimeout
+	// OverflowFailFast immediately fails with QueueFullError.
+	OverflowFailFast
+	// OverflowDropOldest drops the oldest queued command of the same priority to make room.
+	// The dropped command fails with CommandDroppedError. If there is nothing to drop (e.g. with a queue buffer of 0), it blocks instead.
+	OverflowDropOldest
+	// OverflowSpill moves the command to the overflow store, from where it is queued once there is room.
+	// Without a queue buffer, the idle workers take the spilled commands directly.
+	OverflowSpill
+)
+
+// OverflowPolicy describes how async commands are handled when the queue is full.
+// Timeout is required by OverflowBlockTimeout and Store by OverflowSpill (defaulting to a MemoryOverflowStore).
+type OverflowPolicy struct {
+	Strategy OverflowStrategy
+	Timeout  time.Duration
+	Store    OverflowStore
+}
+
+// OverflowStore must be implemented for a type to qualify as an overflow store.
+// It holds the async commands that do not fit the queue, which are expected to be popped in the order they were pushed.
+type OverflowStore interface {
+	Push(async *Async) error
+	Pop() (*Async, bool)
+	Len() int
+}
+
+// MemoryOverflowStore is an OverflowStore that keeps the async commands in memory.
+type MemoryOverflowStore struct {
+	sync.Mutex
+	asyncs []*Async
+}
+
+// NewMemoryOverflowStore instantiates the MemoryOverflowStore struct.
+func NewMemoryOverflowStore() *MemoryOverflowStore {
+	return &MemoryOverflowStore{
+		asyncs: make([]*Async, 0),
+	}
+}
+
+// Push appends the async command to the store.
+func (store *MemoryOverflowStore) Push(async *Async) error {
+	store.Lock()
+	store.asyncs = append(store.asyncs, async)
+	store.Unlock()
+	return nil
+}
+
+// Pop removes and returns the oldest async command of the store.
+func (store *MemoryOverflowStore) Pop() (*Async, bool) {
+	store.Lock()
+	defer store.Unlock()
+	if len(store.asyncs) == 0 {
+		return nil, false
+	}
+	async := store.asyncs[0]
+	store.asyncs[0] = nil
+	store.asyncs = store.asyncs[1:]
+	return async, true
+}
+
+// Len returns the number of async commands in the store.
+func (store *MemoryOverflowStore) Len() int {
+	store.Lock()
+	defer store.Unlock()
+	return len(store.asyncs)
+}
@@ -0,0 +1,24 @@
+package command
+
+// OverlapPolicy determines how recurring scheduled commands are issued while their previous run is still pending or
+// being processed.
+type OverlapPolicy int
+
+const (
+	// OverlapAllow issues the command regardless of the previous run.
+	OverlapAllow OverlapPolicy = iota
+	// OverlapSkip skips the run, the command is only issued on its next occurrence.
+	OverlapSkip
+	// OverlapQueue holds (at most) one run, which is issued once the previous run completes.
+	OverlapQueue
+	// OverlapReplace cancels the previous run before issuing the command.
+	// Pending runs fail with CommandCanceledError, while the context of the runs being processed is canceled with
+	// ScheduledCommandReplacedError as the cause. It is up to the handlers to respect the context.
+	OverlapReplace
+)
+
+// Overlapper may optionally be implemented by scheduled commands to determine their own overlap policy.
+// It takes precedence over the overlap policy set in the bus.
+type Overlapper interface {
+	OverlapPolicy() OverlapPolicy
+}
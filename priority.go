@@ -0,0 +1,25 @@
+package command
+
+// Priority determines the order in which the queued async commands are processed.
+type Priority int
+
+const (
+	// PriorityLow is used for commands that may be postponed in favor of the remaining ones.
+	PriorityLow Priority = iota
+	// PriorityNormal is the default priority of the commands.
+	PriorityNormal
+	// PriorityHigh is used for commands that should be processed before the remaining ones.
+	PriorityHigh
+)
+
+// Prioritized may optionally be implemented by commands to determine their priority when processed asynchronously.
+type Prioritized interface {
+	Priority() Priority
+}
+
+func priorityOf(cmd Command) Priority {
+	if prioritized, ok := cmd.(Prioritized); ok {
+		return prioritized.Priority()
+	}
+	return PriorityNormal
+}
@@ -1,10 +1,14 @@
 package command
 
 import (
+	"cmp"
+	"context"
+	"slices"
 	"sync"
 	"time"
 
 	"github.com/google/uuid"
+	"github.com/io-da/schedule"
 )
 
 type scheduleProcessor struct {
@@ -13,77 +17,332 @@ type scheduleProcessor struct {
 	scheduledCommands map[uuid.UUID]*scheduledCommand
 	triggerSignal     chan bool
 	shuttingDown      *flag
-	sleepTimer        *time.Timer
+	stopped           chan bool
+	sleepTimerStop    func() bool
 	sleepUntil        time.Time
+	sequence          uint64
+	writes            map[uuid.UUID]*scheduleWrite
+	writesMutex       sync.Mutex
+}
+
+// scheduleWrite is a pending change of the record of a persisted scheduled command.
+type scheduleWrite struct {
+	ctx context.Context
+	cmd Command
+	// rec is nil when the record is to be removed
+	rec *ScheduleRecord
 }
 
 func newScheduleProcessor(bus *Bus) *scheduleProcessor {
 	pro := &scheduleProcessor{
 		bus:               bus,
 		scheduledCommands: make(map[uuid.UUID]*scheduledCommand),
+		writes:            make(map[uuid.UUID]*scheduleWrite),
 		triggerSignal:     make(chan bool, 1),
 		shuttingDown:      newFlag(),
 	}
-	go pro.process()
 	return pro
 }
 
-func (pro *scheduleProcessor) add(schCmd *scheduledCommand) uuid.UUID {
-	pro.Lock()
+// start launches the processor, also after it was previously stopped.
+func (pro *scheduleProcessor) start() {
+	pro.shuttingDown.disable()
+	pro.stopped = make(chan bool)
+	go pro.process()
+}
+
+// add schedules the command, persisting it if it implements PersistentSchedule and a store was provided.
+func (pro *scheduleProcessor) add(schCmd *scheduledCommand) (uuid.UUID, error) {
 	key := uuid.New()
+	schCmd.createdAt = pro.bus.clock.Now()
+	_, schCmd.persistent = unwrap(schCmd.cmd).(PersistentSchedule)
+	schCmd.persistent = schCmd.persistent && pro.bus.scheduleStore != nil
+	if schCmd.persistent {
+		// the command is not scheduled yet, so its record is stored before any other change of it
+		if err := pro.bus.scheduleStore.Store(schCmd.record(key)); err != nil {
+			return uuid.Nil, err
+		}
+	}
+	pro.Lock()
+	pro.sequence++
+	schCmd.seq = pro.sequence
 	pro.scheduledCommands[key] = schCmd
 	pro.Unlock()
 	pro.trigger()
-	return key
+	return key, nil
 }
 
 func (pro *scheduleProcessor) remove(keys ...uuid.UUID) {
 	pro.Lock()
 	for _, key := range keys {
-		delete(pro.scheduledCommands, key)
+		pro.delete(key)
+	}
+	pro.Unlock()
+	pro.flush()
+	pro.trigger()
+}
+
+// reload schedules the commands persisted in the store with their original keys.
+// The schedules are rebuilt and resumed from their persisted next run.
+func (pro *scheduleProcessor) reload() error {
+	store := pro.bus.scheduleStore
+	if store == nil {
+		return nil
+	}
+	records, err := store.Load()
+	if err != nil {
+		return err
+	}
+	pro.Lock()
+	defer pro.Unlock()
+	for _, rec := range records {
+		if _, ok := pro.scheduledCommands[rec.Key]; ok {
+			continue
+		}
+		persistent, ok := rec.Command.(PersistentSchedule)
+		if !ok {
+			// the record can never be reloaded (its command could not be decoded or is not persistent),
+			// so it is reported and removed
+			cause := rec.Err
+			if cause == nil {
+				cause = InvalidCommandError
+			}
+			cmdErr := newCommandError(context.Background(), rec.Command, StageSchedule, cause)
+			cmdErr.Identifier = rec.Identifier
+			pro.bus.report(cmdErr)
+			if err = store.Remove(rec.Key); err != nil {
+				return err
+			}
+			continue
+		}
+		schCmd := newScheduledCommand(context.Background(), rec.Command, persistent.Schedule())
+		schCmd.persistent = true
+		schCmd.paused = rec.Paused
+		schCmd.createdAt = rec.CreatedAt
+		if !schCmd.skip(rec.NextRun.Add(-time.Nanosecond)) {
+			// every occurrence was already issued
+			if err = store.Remove(rec.Key); err != nil {
+				return err
+			}
+			continue
+		}
+		pro.sequence++
+		schCmd.seq = pro.sequence
+		pro.scheduledCommands[rec.Key] = schCmd
+	}
+	return nil
+}
+
+// persist queues the update of the record of the scheduled command (if persisted), it must be called while locked.
+// The pending writes are only performed by flush, once unlocked.
+func (pro *scheduleProcessor) persist(key uuid.UUID, schCmd *scheduledCommand) {
+	if !schCmd.persistent {
+		return
+	}
+	pro.writes[key] = &scheduleWrite{ctx: schCmd.ctx, cmd: unwrap(schCmd.cmd), rec: schCmd.record(key)}
+}
+
+// delete removes the scheduled command and queues the removal of its record (if persisted), it must be called while locked.
+func (pro *scheduleProcessor) delete(key uuid.UUID) {
+	schCmd, ok := pro.scheduledCommands[key]
+	if !ok {
+		return
+	}
+	delete(pro.scheduledCommands, key)
+	if !schCmd.persistent {
+		return
+	}
+	pro.writes[key] = &scheduleWrite{ctx: schCmd.ctx, cmd: unwrap(schCmd.cmd)}
+}
+
+// flush performs the pending writes to the store without holding the lock, so that slow stores do not stall scheduling.
+// Only the latest write of each record is performed, flushes are serialized to preserve the order of the writes.
+func (pro *scheduleProcessor) flush() {
+	pro.writesMutex.Lock()
+	defer pro.writesMutex.Unlock()
+	pro.Lock()
+	writes := pro.writes
+	if len(writes) > 0 {
+		pro.writes = make(map[uuid.UUID]*scheduleWrite)
+	}
+	pro.Unlock()
+	for key, write := range writes {
+		var err error
+		if write.rec == nil {
+			err = pro.bus.scheduleStore.Remove(key)
+		} else {
+			err = pro.bus.scheduleStore.Store(write.rec)
+		}
+		if err != nil {
+			pro.bus.error(write.ctx, write.cmd, StageSchedule, err)
+		}
+	}
+}
+
+// entries returns the scheduled commands ordered by creation time.
+func (pro *scheduleProcessor) entries() []*ScheduledEntry {
+	pro.Lock()
+	keys := make([]uuid.UUID, 0, len(pro.scheduledCommands))
+	for key := range pro.scheduledCommands {
+		keys = append(keys, key)
+	}
+	// commands scheduled at the same time are ordered by the sequence in which they were scheduled
+	slices.SortFunc(keys, func(a, b uuid.UUID) int {
+		schCmdA, schCmdB := pro.scheduledCommands[a], pro.scheduledCommands[b]
+		if order := schCmdA.createdAt.Compare(schCmdB.createdAt); order != 0 {
+			return order
+		}
+		return cmp.Compare(schCmdA.seq, schCmdB.seq)
+	})
+	entries := make([]*ScheduledEntry, 0, len(keys))
+	for _, key := range keys {
+		entries = append(entries, pro.scheduledCommands[key].entry(key))
 	}
 	pro.Unlock()
+	return entries
+}
+
+func (pro *scheduleProcessor) inspect(key uuid.UUID) (*ScheduledEntry, error) {
+	pro.Lock()
+	defer pro.Unlock()
+	schCmd, ok := pro.scheduledCommands[key]
+	if !ok {
+		return nil, ScheduledCommandNotFoundError
+	}
+	return schCmd.entry(key), nil
+}
+
+func (pro *scheduleProcessor) pause(keys ...uuid.UUID) {
+	pro.Lock()
+	for _, key := range keys {
+		if schCmd, ok := pro.scheduledCommands[key]; ok && !schCmd.paused {
+			schCmd.paused = true
+			pro.persist(key, schCmd)
+		}
+	}
+	pro.Unlock()
+	pro.flush()
 	pro.trigger()
 }
 
-func (pro *scheduleProcessor) shutdown() {
+// resume resumes the paused commands, skipping the occurrences that were due while paused.
+func (pro *scheduleProcessor) resume(keys ...uuid.UUID) {
+	pro.Lock()
+	now := pro.bus.clock.Now()
+	for _, key := range keys {
+		schCmd, ok := pro.scheduledCommands[key]
+		if !ok || !schCmd.paused {
+			continue
+		}
+		schCmd.paused = false
+		if !schCmd.skip(now) {
+			pro.delete(key)
+			continue
+		}
+		pro.persist(key, schCmd)
+	}
+	pro.Unlock()
+	pro.flush()
+	pro.trigger()
+}
+
+func (pro *scheduleProcessor) reschedule(key uuid.UUID, sch *schedule.Schedule) error {
+	pro.Lock()
+	schCmd, ok := pro.scheduledCommands[key]
+	if ok {
+		schCmd.sch = sch
+		pro.persist(key, schCmd)
+	}
+	pro.Unlock()
+	if !ok {
+		return ScheduledCommandNotFoundError
+	}
+	pro.flush()
+	pro.trigger()
+	return nil
+}
+
+// shutdown stops the processor, returning a channel that is closed once it is stopped.
+func (pro *scheduleProcessor) shutdown() <-chan bool {
 	if pro.shuttingDown.enable() {
 		pro.trigger()
 	}
+	return pro.stopped
 }
 
 func (pro *scheduleProcessor) process() {
+	defer close(pro.stopped)
+	// the pending writes are completed before the processor is considered stopped
+	defer pro.flush()
+	defer pro.stopSleepTimer()
 	for !pro.shuttingDown.enabled() {
 		pro.Lock()
-		now := time.Now()
+		now := pro.bus.clock.Now()
 		pro.sleepUntil = time.Time{}
 		for key, schCmd := range pro.scheduledCommands {
-			following := schCmd.sch.Following()
-			if following.IsZero() {
-				_ = schCmd.sch.Next()
-				following = schCmd.sch.Following()
+			if schCmd.paused {
+				continue
 			}
-
-			if now.After(following) || now.Equal(following) {
-				async := newAsync(schCmd.hdl, schCmd.cmd)
-				pro.bus.asyncCommandsQueue <- async
-				if err := schCmd.sch.Next(); err != nil {
-					delete(pro.scheduledCommands, key)
-					continue
-				}
-				following = schCmd.sch.Following()
+			if schCmd.dequeue() {
+				pro.issue(key, schCmd, now)
+			}
+			following := schCmd.following()
+			if following.After(now) {
+				pro.updateSleepUntil(following)
+				continue
+			}
+			due, last, ok := schCmd.advance(now)
+			fires := pro.bus.misfirePolicyOf(unwrap(schCmd.cmd)).fires(due, now.Sub(last))
+			for i := 0; i < fires; i++ {
+				pro.fire(key, schCmd, now)
 			}
-			pro.updateSleepUntil(following)
+			if !ok {
+				pro.delete(key)
+				continue
+			}
+			pro.persist(key, schCmd)
+			pro.updateSleepUntil(schCmd.sch.Following())
 		}
 		pro.updateSleepTimer(pro.determineSleepDuration())
+		pending := len(pro.writes) > 0
 		pro.Unlock()
+		if pending {
+			// the records are written in the background, slow stores must not delay the following runs
+			go pro.flush()
+		}
+
+		// the processor is triggered either by the sleep timer or directly
+		<-pro.triggerSignal
+	}
+}
 
-		// allow the processor to be triggered either with timer or directly
-		select {
-		case <-pro.sleepTimer.C:
-		case <-pro.triggerSignal:
+// fire issues the scheduled command according to its overlap policy, it must be called while locked.
+func (pro *scheduleProcessor) fire(key uuid.UUID, schCmd *scheduledCommand, now time.Time) {
+	if schCmd.overlapping() {
+		switch pro.bus.overlapPolicyOf(unwrap(schCmd.cmd)) {
+		case OverlapSkip:
+			return
+		case OverlapQueue:
+			schCmd.queue()
+			return
+		case OverlapReplace:
+			schCmd.replace()
 		}
 	}
+	pro.issue(key, schCmd, now)
+}
+
+// issue enqueues a run of the scheduled command, it must be called while locked.
+func (pro *scheduleProcessor) issue(key uuid.UUID, schCmd *scheduledCommand, now time.Time) {
+	// the context is canceled once the run completes, the async commands issued with it are detached from it
+	ctx, cancel := context.WithCancelCause(schCmd.ctx)
+	ctx, env := envelop(ctx, schCmd.command(now), now)
+	async := newAsync(ctx, env, now)
+	async.scheduleKey = key
+	schCmd.run(async, cancel, now, pro.trigger)
+	if err := pro.bus.enqueue(async); err != nil {
+		pro.bus.reject(async, StageSchedule, err)
+	}
 }
 
 func (pro *scheduleProcessor) trigger() {
@@ -103,13 +362,17 @@ func (pro *scheduleProcessor) determineSleepDuration() time.Duration {
 	if pro.sleepUntil.IsZero() || len(pro.scheduledCommands) <= 0 {
 		return time.Hour
 	}
-	return time.Until(pro.sleepUntil)
+	return pro.sleepUntil.Sub(pro.bus.clock.Now())
 }
 
 func (pro *scheduleProcessor) updateSleepTimer(d time.Duration) {
-	if pro.sleepTimer == nil {
-		pro.sleepTimer = time.NewTimer(d)
-		return
+	pro.stopSleepTimer()
+	pro.sleepTimerStop = pro.bus.clock.AfterFunc(d, pro.trigger)
+}
+
+func (pro *scheduleProcessor) stopSleepTimer() {
+	if pro.sleepTimerStop != nil {
+		pro.sleepTimerStop()
+		pro.sleepTimerStop = nil
 	}
-	pro.sleepTimer.Reset(d)
 }
@@ -0,0 +1,94 @@
+package command
+
+import (
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/io-da/schedule"
+)
+
+// PersistentSchedule must be implemented by scheduled commands for them to be persisted in the ScheduleStore.
+// Schedules cannot be serialized, so the schedule of reloaded commands is rebuilt using Schedule and resumed from
+// their persisted next run. It should therefore return the same schedule the command was scheduled with.
+type PersistentSchedule interface {
+	Schedule() *schedule.Schedule
+}
+
+// ScheduleRecord holds a persisted scheduled command.
+type ScheduleRecord struct {
+	Key        uuid.UUID
+	Identifier Identifier
+	Command    Command
+	NextRun    time.Time
+	Paused     bool
+	CreatedAt  time.Time
+	// Err is the error that prevented the store from decoding the command, in which case Command is nil.
+	Err error
+	// raw is the encoded command of records that could not be decoded, kept as is until they are removed
+	raw []byte
+}
+
+// ScheduleStore must be implemented for a type to qualify as a store of scheduled commands.
+// The records are expected to be loaded in the order they were created.
+type ScheduleStore interface {
+	// Store adds the record to the store, replacing any record with the same key.
+	Store(rec *ScheduleRecord) error
+	Load() ([]*ScheduleRecord, error)
+	Remove(keys ...uuid.UUID) error
+}
+
+// MemoryScheduleStore is a ScheduleStore that keeps the records in memory.
+type MemoryScheduleStore struct {
+	sync.Mutex
+	records []*ScheduleRecord
+}
+
+// NewMemoryScheduleStore instantiates the MemoryScheduleStore struct.
+func NewMemoryScheduleStore() *MemoryScheduleStore {
+	return &MemoryScheduleStore{
+		records: make([]*ScheduleRecord, 0),
+	}
+}
+
+// Store adds the record to the store, replacing any record with the same key.
+func (store *MemoryScheduleStore) Store(rec *ScheduleRecord) error {
+	store.Lock()
+	store.records = upsertScheduleRecord(store.records, rec)
+	store.Unlock()
+	return nil
+}
+
+// Load returns all the stored records.
+func (store *MemoryScheduleStore) Load() ([]*ScheduleRecord, error) {
+	store.Lock()
+	defer store.Unlock()
+	return append([]*ScheduleRecord(nil), store.records...), nil
+}
+
+// Remove deletes the records with the provided keys from the store.
+func (store *MemoryScheduleStore) Remove(keys ...uuid.UUID) error {
+	store.Lock()
+	store.records = removeScheduleRecords(store.records, keys)
+	store.Unlock()
+	return nil
+}
+
+//------Internal------//
+
+func upsertScheduleRecord(records []*ScheduleRecord, rec *ScheduleRecord) []*ScheduleRecord {
+	for i, stored := range records {
+		if stored.Key == rec.Key {
+			records[i] = rec
+			return records
+		}
+	}
+	return append(records, rec)
+}
+
+func removeScheduleRecords(records []*ScheduleRecord, keys []uuid.UUID) []*ScheduleRecord {
+	return slices.DeleteFunc(records, func(rec *ScheduleRecord) bool {
+		return slices.Contains(keys, rec.Key)
+	})
+}
@@ -0,0 +1,133 @@
+package command
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// FileScheduleStore is a ScheduleStore that persists the records to a JSON file.
+// The commands are serialized using the provided CommandCodec.
+type FileScheduleStore struct {
+	sync.Mutex
+	path    string
+	codec   CommandCodec
+	records []*ScheduleRecord
+}
+
+// NewFileScheduleStore instantiates the FileScheduleStore struct.
+// Previously persisted records are loaded from the file, if it exists. Records whose command can not be decoded are
+// loaded with their error instead (see ScheduleRecord), so the bus reports and removes them once started.
+func NewFileScheduleStore(path string, codec CommandCodec) (*FileScheduleStore, error) {
+	store := &FileScheduleStore{
+		path:    path,
+		codec:   codec,
+		records: make([]*ScheduleRecord, 0),
+	}
+	if err := store.read(); err != nil {
+		return nil, err
+	}
+	return store, nil
+}
+
+// Store adds the record to the store, replacing any record with the same key, and persists it.
+func (store *FileScheduleStore) Store(rec *ScheduleRecord) error {
+	store.Lock()
+	defer store.Unlock()
+	records := upsertScheduleRecord(append([]*ScheduleRecord(nil), store.records...), rec)
+	if err := store.write(records); err != nil {
+		return err
+	}
+	store.records = records
+	return nil
+}
+
+// Load returns all the stored records.
+func (store *FileScheduleStore) Load() ([]*ScheduleRecord, error) {
+	store.Lock()
+	defer store.Unlock()
+	return append([]*ScheduleRecord(nil), store.records...), nil
+}
+
+// Remove deletes the records with the provided keys from the store.
+func (store *FileScheduleStore) Remove(keys ...uuid.UUID) error {
+	store.Lock()
+	defer store.Unlock()
+	records := removeScheduleRecords(append([]*ScheduleRecord(nil), store.records...), keys)
+	if err := store.write(records); err != nil {
+		return err
+	}
+	store.records = records
+	return nil
+}
+
+//------Internal------//
+
+type scheduleRecord struct {
+	Key        uuid.UUID       `json:"key"`
+	Identifier Identifier      `json:"identifier"`
+	Command    json.RawMessage `json:"command"`
+	NextRun    time.Time       `json:"next_run"`
+	Paused     bool            `json:"paused"`
+	CreatedAt  time.Time       `json:"created_at"`
+}
+
+func (store *FileScheduleStore) read() error {
+	data, err := os.ReadFile(store.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var records []scheduleRecord
+	if err = json.Unmarshal(data, &records); err != nil {
+		return err
+	}
+	for _, rec := range records {
+		loaded := &ScheduleRecord{
+			Key:        rec.Key,
+			Identifier: rec.Identifier,
+			NextRun:    rec.NextRun,
+			Paused:     rec.Paused,
+			CreatedAt:  rec.CreatedAt,
+		}
+		if loaded.Command, loaded.Err = store.codec.Decode(rec.Identifier, rec.Command); loaded.Err != nil {
+			// a single command that can no longer be decoded must not prevent the others from being loaded
+			loaded.raw = rec.Command
+		}
+		store.records = append(store.records, loaded)
+	}
+	return nil
+}
+
+func (store *FileScheduleStore) write(records []*ScheduleRecord) error {
+	persisted := make([]scheduleRecord, len(records))
+	for i, rec := range records {
+		cmd := rec.raw
+		if cmd == nil {
+			encoded, err := store.codec.Encode(rec.Command)
+			if err != nil {
+				return err
+			}
+			cmd = encoded
+		}
+		persisted[i] = scheduleRecord{
+			Key:        rec.Key,
+			Identifier: rec.Identifier,
+			Command:    cmd,
+			NextRun:    rec.NextRun,
+			Paused:     rec.Paused,
+			CreatedAt:  rec.CreatedAt,
+		}
+	}
+	data, err := json.Marshal(persisted)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(store.path, data)
+}
@@ -1,17 +1,200 @@
 package command
 
-import "github.com/io-da/schedule"
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/io-da/schedule"
+)
+
+// ScheduledEntry describes a scheduled command, as returned by Scheduled and InspectScheduled.
+type ScheduledEntry struct {
+	// Key is the key returned by Schedule.
+	Key uuid.UUID
+	// Identifier of the scheduled command.
+	Identifier Identifier
+	// Command is the scheduled command.
+	Command Command
+	// NextRun is the time at which the command is next issued.
+	NextRun time.Time
+	// Runs is the number of times the command was issued.
+	Runs int
+	// LastRunAt is the time at which the command was last issued, or zero if it was never issued.
+	LastRunAt time.Time
+	// LastResult is the result of the last execution that completed.
+	LastResult any
+	// LastErr is the error of the last execution that completed (if any).
+	LastErr error
+	// CreatedAt is the time at which the command was scheduled.
+	CreatedAt time.Time
+	// Paused is true while the command is paused.
+	Paused bool
+	// Running is true while the last run is pending or being processed.
+	Running bool
+}
 
 type scheduledCommand struct {
-	hdl Handler
-	cmd Command
-	sch *schedule.Schedule
+	sync.Mutex
+	ctx        context.Context
+	cmd        Command
+	sch        *schedule.Schedule
+	paused     bool
+	persistent bool
+	createdAt  time.Time
+	seq        uint64
+	runs       int
+	lastRunAt  time.Time
+	lastResult any
+	lastErr    error
+	running    *Async
+	cancel     context.CancelCauseFunc
+	queued     bool
 }
 
-func newScheduledCommand(hdl Handler, cmd Command, sch *schedule.Schedule) *scheduledCommand {
+func newScheduledCommand(ctx context.Context, cmd Command, sch *schedule.Schedule) *scheduledCommand {
 	return &scheduledCommand{
-		hdl: hdl,
+		ctx: ctx,
 		cmd: cmd,
 		sch: sch,
 	}
 }
+
+// command returns the command to be executed, scheduled envelopes are renewed for every execution.
+func (schCmd *scheduledCommand) command(now time.Time) Command {
+	if env, ok := schCmd.cmd.(*Envelope); ok && env != nil {
+		return env.renew(now)
+	}
+	return schCmd.cmd
+}
+
+// following returns the time at which the command is next issued, starting the schedule if necessary.
+func (schCmd *scheduledCommand) following() time.Time {
+	following := schCmd.sch.Following()
+	if following.IsZero() {
+		_ = schCmd.sch.Next()
+		following = schCmd.sch.Following()
+	}
+	return following
+}
+
+// skip advances the schedule past the occurrences that are already due.
+// It returns false if the schedule has no further occurrences.
+func (schCmd *scheduledCommand) skip(now time.Time) bool {
+	for !schCmd.following().After(now) {
+		if err := schCmd.sch.Next(); err != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// advance moves the schedule past the occurrences that are due, returning how many were due and the last one.
+// It also returns false if the schedule has no further occurrences.
+func (schCmd *scheduledCommand) advance(now time.Time) (int, time.Time, bool) {
+	due := 0
+	var last time.Time
+	for following := schCmd.following(); !following.After(now); following = schCmd.sch.Following() {
+		due++
+		last = following
+		if err := schCmd.sch.Next(); err != nil {
+			return due, last, false
+		}
+	}
+	return due, last, true
+}
+
+// run records that the command was issued, along with the result of its execution once it completes.
+// The provided function is invoked once the execution completes while another run is queued.
+func (schCmd *scheduledCommand) run(async *Async, cancel context.CancelCauseFunc, now time.Time, dequeue func()) {
+	schCmd.Lock()
+	schCmd.runs++
+	schCmd.lastRunAt = now
+	schCmd.running, schCmd.cancel = async, cancel
+	schCmd.Unlock()
+	async.addListener(func(as *Async) {
+		cancel(nil)
+		schCmd.Lock()
+		schCmd.lastResult, schCmd.lastErr = as.data, as.err
+		if schCmd.running == as {
+			schCmd.running, schCmd.cancel = nil, nil
+		}
+		queued := schCmd.queued
+		schCmd.Unlock()
+		if queued {
+			dequeue()
+		}
+	})
+}
+
+// overlapping reports whether the last run is still pending or being processed.
+func (schCmd *scheduledCommand) overlapping() bool {
+	schCmd.Lock()
+	defer schCmd.Unlock()
+	return schCmd.running != nil
+}
+
+// queue holds a run until the last run completes.
+func (schCmd *scheduledCommand) queue() {
+	schCmd.Lock()
+	schCmd.queued = true
+	schCmd.Unlock()
+}
+
+// dequeue reports whether a run was queued and the last run completed, in which case the queued run is released.
+func (schCmd *scheduledCommand) dequeue() bool {
+	schCmd.Lock()
+	defer schCmd.Unlock()
+	if !schCmd.queued || schCmd.running != nil {
+		return false
+	}
+	schCmd.queued = false
+	return true
+}
+
+// replace cancels the last run, if it is still pending or being processed.
+func (schCmd *scheduledCommand) replace() {
+	schCmd.Lock()
+	running, cancel := schCmd.running, schCmd.cancel
+	schCmd.running, schCmd.cancel = nil, nil
+	schCmd.Unlock()
+	if running == nil {
+		return
+	}
+	if !running.Cancel() {
+		cancel(ScheduledCommandReplacedError)
+	}
+}
+
+// record describes the scheduled command to be persisted.
+func (schCmd *scheduledCommand) record(key uuid.UUID) *ScheduleRecord {
+	cmd := unwrap(schCmd.cmd)
+	return &ScheduleRecord{
+		Key:        key,
+		Identifier: cmd.Identifier(),
+		Command:    cmd,
+		NextRun:    schCmd.following(),
+		Paused:     schCmd.paused,
+		CreatedAt:  schCmd.createdAt,
+	}
+}
+
+func (schCmd *scheduledCommand) entry(key uuid.UUID) *ScheduledEntry {
+	schCmd.Lock()
+	defer schCmd.Unlock()
+	cmd := unwrap(schCmd.cmd)
+	return &ScheduledEntry{
+		Key:        key,
+		Identifier: cmd.Identifier(),
+		Command:    cmd,
+		NextRun:    schCmd.following(),
+		Runs:       schCmd.runs,
+		LastRunAt:  schCmd.lastRunAt,
+		LastResult: schCmd.lastResult,
+		LastErr:    schCmd.lastErr,
+		CreatedAt:  schCmd.createdAt,
+		Paused:     schCmd.paused,
+		Running:    schCmd.running != nil,
+	}
+}
@@ -0,0 +1,9 @@
+package command
+
+import "time"
+
+// Timeouter may optionally be implemented by commands to determine their own execution timeout.
+// It takes precedence over the timeouts set in the bus, a zero duration disables the timeout.
+type Timeouter interface {
+	Timeout() time.Duration
+}
@@ -0,0 +1,163 @@
+package command
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"time"
+)
+
+// HandlerFunc is the function type used to handle commands of type C producing results of type R.
+type HandlerFunc[C Command, R any] func(ctx context.Context, cmd C) (R, error)
+
+// TypedHandler adapts a HandlerFunc to the ContextHandler interface.
+// The type assertion of the commands is performed by the TypedHandler itself.
+type TypedHandler[C Command, R any] struct {
+	identifier Identifier
+	fn         HandlerFunc[C, R]
+}
+
+// NewTypedHandler instantiates a TypedHandler handling the commands with the provided identifier.
+func NewTypedHandler[C Command, R any](identifier Identifier, fn HandlerFunc[C, R]) *TypedHandler[C, R] {
+	return &TypedHandler[C, R]{
+		identifier: identifier,
+		fn:         fn,
+	}
+}
+
+// Handles returns the identifier of the commands handled.
+func (hdl *TypedHandler[C, R]) Handles() Identifier {
+	return hdl.identifier
+}
+
+// Handle processes the command without context.
+func (hdl *TypedHandler[C, R]) Handle(cmd Command) (any, error) {
+	return hdl.HandleContext(context.Background(), cmd)
+}
+
+// HandleContext asserts the command type and provides it to the HandlerFunc.
+// A *CommandTypeError is returned if the command is not of type C.
+func (hdl *TypedHandler[C, R]) HandleContext(ctx context.Context, cmd Command) (any, error) {
+	typedCmd, ok := cmd.(C)
+	if !ok {
+		return nil, &CommandTypeError{
+			Identifier: hdl.identifier,
+			Expected:   typeOf[C](),
+			Actual:     reflect.TypeOf(cmd),
+		}
+	}
+	return hdl.fn(ctx, typedCmd)
+}
+
+// Register provides the bus a TypedHandler for the commands with the provided identifier.
+// It follows the same rules as Bus.Register.
+func Register[C Command, R any](bus *Bus, identifier Identifier, fn HandlerFunc[C, R]) error {
+	return bus.Register(NewTypedHandler(identifier, fn))
+}
+
+// Dispatch processes the command synchronously and asserts the result to type R.
+// A *ResultTypeError is returned if the result is not of type R.
+func Dispatch[R any](bus *Bus, cmd Command) (R, error) {
+	return DispatchContext[R](context.Background(), bus, cmd)
+}
+
+// DispatchContext processes the command synchronously with the provided context and asserts the result to type R.
+func DispatchContext[R any](ctx context.Context, bus *Bus, cmd Command) (R, error) {
+	data, err := bus.HandleContext(ctx, cmd)
+	return typedResult[R](cmd, data, err)
+}
+
+// DispatchAsync processes the command asynchronously, returning a *TypedAsync to await for results of type R.
+func DispatchAsync[R any](bus *Bus, cmd Command) (*TypedAsync[R], error) {
+	return DispatchAsyncContext[R](context.Background(), bus, cmd)
+}
+
+// DispatchAsyncContext processes the command asynchronously with the provided context.
+// It returns a *TypedAsync to await for results of type R.
+func DispatchAsyncContext[R any](ctx context.Context, bus *Bus, cmd Command) (*TypedAsync[R], error) {
+	async, err := bus.HandleAsyncContext(ctx, cmd)
+	if err != nil {
+		return nil, err
+	}
+	return &TypedAsync[R]{async: async}, nil
+}
+
+// TypedAsync is the struct returned from typed async commands.
+// It mirrors *Async while asserting the results to type R.
+type TypedAsync[R any] struct {
+	async *Async
+}
+
+// Await for the command to be processed.
+func (as *TypedAsync[R]) Await() (R, error) {
+	data, err := as.async.Await()
+	return typedResult[R](as.async.cmd, data, err)
+}
+
+// AwaitContext waits for the command to be processed or for the context to be done.
+func (as *TypedAsync[R]) AwaitContext(ctx context.Context) (R, error) {
+	data, err := as.async.AwaitContext(ctx)
+	return typedResult[R](as.async.cmd, data, err)
+}
+
+// AwaitTimeout waits for the command to be processed for at most the provided duration.
+func (as *TypedAsync[R]) AwaitTimeout(d time.Duration) (R, error) {
+	data, err := as.async.AwaitTimeout(d)
+	return typedResult[R](as.async.cmd, data, err)
+}
+
+// Cancel prevents the command from being processed if it was not yet picked up by a worker.
+func (as *TypedAsync[R]) Cancel() bool {
+	return as.async.Cancel()
+}
+
+// Async returns the underlying *Async, allowing it to be used with an *AsyncList.
+func (as *TypedAsync[R]) Async() *Async {
+	return as.async
+}
+
+// CommandTypeError will be returned when a TypedHandler is provided a command of an unexpected type.
+type CommandTypeError struct {
+	Identifier Identifier
+	Expected   reflect.Type
+	Actual     reflect.Type
+}
+
+// Error returns the string message of the error.
+func (e *CommandTypeError) Error() string {
+	return fmt.Sprintf("command: unexpected command type %v for %s, expected %v", e.Actual, e.Identifier, e.Expected)
+}
+
+// ResultTypeError will be returned when the result of a command does not match the type expected by the caller.
+type ResultTypeError struct {
+	Identifier Identifier
+	Expected   reflect.Type
+	Actual     reflect.Type
+}
+
+// Error returns the string message of the error.
+func (e *ResultTypeError) Error() string {
+	return fmt.Sprintf("command: unexpected result type %v for %s, expected %v", e.Actual, e.Identifier, e.Expected)
+}
+
+//------Internal------//
+
+func typedResult[R any](cmd Command, data any, err error) (R, error) {
+	var res R
+	if err != nil || data == nil {
+		return res, err
+	}
+	res, ok := data.(R)
+	if !ok {
+		return res, &ResultTypeError{
+			Identifier: cmd.Identifier(),
+			Expected:   typeOf[R](),
+			Actual:     reflect.TypeOf(data),
+		}
+	}
+	return res, nil
+}
+
+func typeOf[T any]() reflect.Type {
+	return reflect.TypeOf((*T)(nil)).Elem()
+}
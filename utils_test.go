@@ -1,12 +1,15 @@
 package command
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"math/big"
 	"sync"
 	"testing"
 	"time"
+
+	"github.com/io-da/schedule"
 )
 
 // ------Enums------//
@@ -68,6 +71,91 @@ func (*testCommandError) Identifier() Identifier {
 	return TestErrorCommand
 }
 
+type testPrioritizedCommand struct {
+	priority Priority
+}
+
+func (*testPrioritizedCommand) Identifier() Identifier {
+	return TestCommand2
+}
+
+func (cmd *testPrioritizedCommand) Priority() Priority {
+	return cmd.priority
+}
+
+type testTimeoutCommand struct {
+	timeout time.Duration
+}
+
+func (*testTimeoutCommand) Identifier() Identifier {
+	return TestCommand1
+}
+
+func (cmd *testTimeoutCommand) Timeout() time.Duration {
+	return cmd.timeout
+}
+
+type testIdempotentCommand struct {
+	key string
+}
+
+func (*testIdempotentCommand) Identifier() Identifier {
+	return TestCommand1
+}
+
+func (cmd *testIdempotentCommand) IdempotencyKey() string {
+	return cmd.key
+}
+
+type testCoalescableCommand struct {
+	key string
+}
+
+func (*testCoalescableCommand) Identifier() Identifier {
+	return TestCommand1
+}
+
+func (cmd *testCoalescableCommand) CoalesceKey() string {
+	return cmd.key
+}
+
+type testPersistentCommand struct {
+	At time.Time `json:"at"`
+}
+
+func (*testPersistentCommand) Identifier() Identifier {
+	return TestCommand2
+}
+
+func (cmd *testPersistentCommand) Schedule() *schedule.Schedule {
+	return schedule.At(cmd.At, cmd.At.Add(time.Hour))
+}
+
+type testMisfireCommand struct {
+	policy MisfirePolicy
+}
+
+func (*testMisfireCommand) Identifier() Identifier {
+	return TestCommand1
+}
+
+func (cmd *testMisfireCommand) MisfirePolicy() MisfirePolicy {
+	return cmd.policy
+}
+
+type testOverlapCommand struct {
+	identifier Identifier
+	policy     OverlapPolicy
+}
+
+func (cmd *testOverlapCommand) Identifier() Identifier {
+	return cmd.identifier
+}
+
+func (cmd *testOverlapCommand) OverlapPolicy() OverlapPolicy {
+	return cmd.policy
+}
+
 type testFakeClosureCommand struct{}
 
 func (*testFakeClosureCommand) Identifier() Identifier {
@@ -169,6 +257,174 @@ func (hdl *testClosureHandler) Handle(cmd Command) (data any, err error) {
 	return
 }
 
+type testContextKey string
+
+const testContextValueKey testContextKey = "value"
+
+type testContextHandler struct {
+	handles Identifier
+}
+
+func (hdl *testContextHandler) Handles() Identifier {
+	return hdl.handles
+}
+
+func (hdl *testContextHandler) Handle(cmd Command) (data any, err error) {
+	return hdl.HandleContext(context.Background(), cmd)
+}
+
+func (hdl *testContextHandler) HandleContext(ctx context.Context, cmd Command) (data any, err error) {
+	return ctx.Value(testContextValueKey), nil
+}
+
+type testBlockingHandler struct {
+	handles Identifier
+	started chan bool
+	release chan bool
+	handled *counter
+}
+
+func newTestBlockingHandler(handles Identifier) *testBlockingHandler {
+	return &testBlockingHandler{
+		handles: handles,
+		started: make(chan bool, 100),
+		release: make(chan bool),
+		handled: newCounter(),
+	}
+}
+
+func (hdl *testBlockingHandler) Handles() Identifier {
+	return hdl.handles
+}
+
+func (hdl *testBlockingHandler) Handle(cmd Command) (data any, err error) {
+	hdl.started <- true
+	<-hdl.release
+	hdl.handled.increment()
+	return
+}
+
+type testRunawayHandler struct {
+	handles  Identifier
+	started  chan bool
+	canceled chan error
+}
+
+func (hdl *testRunawayHandler) Handles() Identifier {
+	return hdl.handles
+}
+
+func (hdl *testRunawayHandler) Handle(cmd Command) (data any, err error) {
+	return hdl.HandleContext(context.Background(), cmd)
+}
+
+// HandleContext only returns once the context is done.
+func (hdl *testRunawayHandler) HandleContext(ctx context.Context, cmd Command) (data any, err error) {
+	if hdl.started != nil {
+		hdl.started <- true
+	}
+	<-ctx.Done()
+	hdl.canceled <- ctx.Err()
+	return nil, ctx.Err()
+}
+
+type testFlakyHandler struct {
+	handles  Identifier
+	failures uint32
+	attempts *counter
+}
+
+func newTestFlakyHandler(handles Identifier, failures uint32) *testFlakyHandler {
+	return &testFlakyHandler{
+		handles:  handles,
+		failures: failures,
+		attempts: newCounter(),
+	}
+}
+
+func (hdl *testFlakyHandler) Handles() Identifier {
+	return hdl.handles
+}
+
+func (hdl *testFlakyHandler) Handle(cmd Command) (data any, err error) {
+	if hdl.attempts.increment() <= hdl.failures {
+		return nil, errors.New(commandFailedError)
+	}
+	return "ok", nil
+}
+
+type testPanicHandler struct {
+	handles Identifier
+}
+
+func (hdl *testPanicHandler) Handles() Identifier {
+	return hdl.handles
+}
+
+func (hdl *testPanicHandler) Handle(cmd Command) (data any, err error) {
+	panic(commandFailedError)
+}
+
+type testOrderHandler struct {
+	sync.Mutex
+	handles Identifier
+	order   []Priority
+}
+
+func (hdl *testOrderHandler) Handles() Identifier {
+	return hdl.handles
+}
+
+func (hdl *testOrderHandler) Handle(cmd Command) (data any, err error) {
+	hdl.Lock()
+	hdl.order = append(hdl.order, priorityOf(cmd))
+	hdl.Unlock()
+	return
+}
+
+type testEnvelopeHandler struct {
+	bus       *Bus
+	handles   Identifier
+	envelopes chan *Envelope
+}
+
+func (hdl *testEnvelopeHandler) Handles() Identifier {
+	return hdl.handles
+}
+
+func (hdl *testEnvelopeHandler) Handle(cmd Command) (data any, err error) {
+	return hdl.HandleContext(context.Background(), cmd)
+}
+
+// HandleContext dispatches a follow-up TestCommand2 when handling TestCommand1.
+func (hdl *testEnvelopeHandler) HandleContext(ctx context.Context, cmd Command) (data any, err error) {
+	env, _ := EnvelopeFromContext(ctx)
+	hdl.envelopes <- env
+	if _, ok := cmd.(*testCommand1); ok {
+		return hdl.bus.HandleContext(ctx, &testCommand2{})
+	}
+	return env, nil
+}
+
+type testFollowUpHandler struct {
+	bus      *Bus
+	handles  Identifier
+	followUp Command
+}
+
+func (hdl *testFollowUpHandler) Handles() Identifier {
+	return hdl.handles
+}
+
+func (hdl *testFollowUpHandler) Handle(cmd Command) (data any, err error) {
+	return hdl.HandleContext(context.Background(), cmd)
+}
+
+// HandleContext issues the follow-up command asynchronously, returning its *Async.
+func (hdl *testFollowUpHandler) HandleContext(ctx context.Context, cmd Command) (data any, err error) {
+	return hdl.bus.HandleAsyncContext(ctx, hdl.followUp)
+}
+
 //------Error Handlers------//
 
 type storeErrorsHandler struct {
@@ -198,6 +454,24 @@ func (hdl *storeErrorsHandler) key(cmd Command) Identifier {
 	return cmd.Identifier()
 }
 
+type storeEnvelopeErrorsHandler struct {
+	storeErrorsHandler
+	envelopes chan *Envelope
+}
+
+func (hdl *storeEnvelopeErrorsHandler) HandleEnvelope(env *Envelope, err error) {
+	hdl.Handle(env.Command, err)
+	hdl.envelopes <- env
+}
+
+type chanErrorsHandler struct {
+	errs chan error
+}
+
+func (hdl *chanErrorsHandler) Handle(cmd Command, err error) {
+	hdl.errs <- err
+}
+
 // ------Middlewares------//
 
 type testLoggerMiddleware struct {
@@ -241,6 +515,20 @@ func (hdl *testErrorMiddleware) Handle(cmd Command, next Next) (data any, err er
 	return
 }
 
+type testContextMiddleware struct {
+	value string
+}
+
+func (hdl *testContextMiddleware) Handle(cmd Command, next Next) (data any, err error) {
+	return hdl.HandleContext(context.Background(), cmd, func(_ context.Context, cmd Command) (any, error) {
+		return next(cmd)
+	})
+}
+
+func (hdl *testContextMiddleware) HandleContext(ctx context.Context, cmd Command, next ContextNext) (data any, err error) {
+	return next(context.WithValue(ctx, testContextValueKey, hdl.value), cmd)
+}
+
 type testMiddleware struct{}
 
 func (hdl *testMiddleware) Handle(cmd Command, next Next) (data any, err error) {
@@ -248,6 +536,24 @@ func (hdl *testMiddleware) Handle(cmd Command, next Next) (data any, err error)
 	return next(cmd)
 }
 
+//------Stores------//
+
+// testBlockingScheduleStore blocks every write after the first one until released.
+type testBlockingScheduleStore struct {
+	ScheduleStore
+	writes  *counter
+	blocked chan bool
+	release chan bool
+}
+
+func (store *testBlockingScheduleStore) Store(rec *ScheduleRecord) error {
+	if store.writes.increment() > 1 {
+		store.blocked <- true
+		<-store.release
+	}
+	return store.ScheduleStore.Store(rec)
+}
+
 //------General------//
 
 var fastFunc = func() { fibonacci(100) }
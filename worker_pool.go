@@ -0,0 +1,314 @@
+package command
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type poolConfig struct {
+	workerPoolSize int
+	queueBuffer    int
+	identifiers    []Identifier
+}
+
+// workerPool holds the queue of async commands and the workers processing them.
+type workerPool struct {
+	sync.Mutex
+	bus       *Bus
+	size      int
+	queue     *asyncQueue
+	workers   *counter
+	pending   *counter
+	retire    chan bool
+	stopped   *flag
+	maxWait   atomic.Int64
+	autoscale *AutoscalePolicy
+	overflow  OverflowPolicy
+	spillLock sync.Mutex
+	spilled   chan bool
+	admission sync.RWMutex
+	closed    bool
+	done      chan bool
+}
+
+func newWorkerPool(bus *Bus, size int, queueBuffer int) *workerPool {
+	return &workerPool{
+		bus:     bus,
+		size:    size,
+		queue:   newAsyncQueue(queueBuffer),
+		workers: newCounter(),
+		pending: newCounter(),
+		retire:  make(chan bool),
+		spilled: make(chan bool, 1),
+		stopped: newFlag(),
+		done:    make(chan bool),
+	}
+}
+
+func (pool *workerPool) start() {
+	pool.Lock()
+	for i := 0; i < pool.size; i++ {
+		pool.spawn()
+	}
+	pool.Unlock()
+	if pool.autoscale != nil {
+		go pool.autoscaler(*pool.autoscale)
+	}
+}
+
+// enqueue queues the async command according to the overflow policy of the pool.
+func (pool *workerPool) enqueue(async *Async) error {
+	// the command is considered pending before being pushed, so that draining never misses it
+	if !pool.admit() {
+		return BusIsShuttingDownError
+	}
+	if err := pool.push(async); err != nil {
+		pool.pending.decrement()
+		return err
+	}
+	return nil
+}
+
+func (pool *workerPool) push(async *Async) error {
+	async.queuedAt = time.Now()
+	switch pool.overflow.Strategy {
+	case OverflowBlockTimeout:
+		if !pool.queue.pushTimeout(async, pool.overflow.Timeout) {
+			return QueueFullError
+		}
+	case OverflowFailFast:
+		return pool.tryPush(async)
+	case OverflowDropOldest:
+		for !pool.queue.tryPush(async) {
+			dropped := pool.queue.dropOldest(async.priority)
+			if dropped == nil {
+				// there is nothing to drop (e.g. unbuffered queues), so it blocks until there is room
+				pool.queue.push(async)
+				break
+			}
+			pool.pending.decrement()
+			pool.bus.drop(dropped)
+		}
+	case OverflowSpill:
+		pool.spillLock.Lock()
+		defer pool.spillLock.Unlock()
+		// spilled commands take precedence to preserve the order
+		if pool.overflow.Store.Len() > 0 || !pool.queue.tryPush(async) {
+			if err := pool.overflow.Store.Push(async); err != nil {
+				return err
+			}
+			pool.wake()
+		}
+	default:
+		pool.queue.push(async)
+	}
+	return nil
+}
+
+func (pool *workerPool) tryEnqueue(async *Async) error {
+	if !pool.admit() {
+		return BusIsShuttingDownError
+	}
+	if err := pool.tryPush(async); err != nil {
+		pool.pending.decrement()
+		return err
+	}
+	return nil
+}
+
+func (pool *workerPool) tryPush(async *Async) error {
+	async.queuedAt = time.Now()
+	if pool.overflow.Strategy == OverflowSpill {
+		pool.spillLock.Lock()
+		defer pool.spillLock.Unlock()
+		if pool.overflow.Store.Len() > 0 {
+			return QueueFullError
+		}
+	}
+	if !pool.queue.tryPush(async) {
+		return QueueFullError
+	}
+	return nil
+}
+
+// unspill moves the spilled commands to the queue while there is room for them.
+func (pool *workerPool) unspill() {
+	pool.spillLock.Lock()
+	defer pool.spillLock.Unlock()
+	for pool.overflow.Store.Len() > 0 && pool.queue.hasRoom() {
+		async, ok := pool.overflow.Store.Pop()
+		if !ok {
+			return
+		}
+		pool.queue.push(async)
+	}
+}
+
+// takeSpilled retrieves the following command for a worker woken up by a spilled command.
+// Unbuffered queues never have room for the spilled commands, so the idle workers take them directly.
+func (pool *workerPool) takeSpilled() *Async {
+	// the queued commands are older than the spilled ones
+	if async := pool.queue.tryPop(); async != nil {
+		// the spilled commands remain to be taken
+		pool.wake()
+		return async
+	}
+	pool.spillLock.Lock()
+	defer pool.spillLock.Unlock()
+	async, _ := pool.overflow.Store.Pop()
+	if pool.overflow.Store.Len() > 0 {
+		pool.wake()
+	}
+	return async
+}
+
+// wake signals an idle worker that commands were spilled.
+func (pool *workerPool) wake() {
+	select {
+	case pool.spilled <- true:
+	default:
+	}
+}
+
+// resize adjusts the number of workers.
+// Retiring workers finish their current command first, resize blocks until they do.
+func (pool *workerPool) resize(size int) {
+	pool.Lock()
+	defer pool.Unlock()
+	if pool.stopped.enabled() {
+		return
+	}
+	for ; pool.size < size; pool.size++ {
+		pool.spawn()
+	}
+	for ; pool.size > size; pool.size-- {
+		pool.retire <- true
+		pool.workers.decrement()
+	}
+}
+
+// admit marks a command as pending, unless the pool was closed.
+func (pool *workerPool) admit() bool {
+	pool.admission.RLock()
+	defer pool.admission.RUnlock()
+	if pool.closed {
+		return false
+	}
+	pool.pending.increment()
+	return true
+}
+
+// close stops accepting commands, once it returns no more commands become pending.
+func (pool *workerPool) close() {
+	pool.admission.Lock()
+	pool.closed = true
+	pool.admission.Unlock()
+}
+
+// drained reports whether all the commands queued were processed.
+func (pool *workerPool) drained() bool {
+	return pool.pending.is(0)
+}
+
+// abandon rejects all the queued commands (including the spilled ones), returning how many were rejected.
+func (pool *workerPool) abandon() int {
+	abandoned := 0
+	reject := func(async *Async) {
+		pool.pending.decrement()
+		if pool.bus.reject(async, StageShutdown, BusIsShuttingDownError) {
+			abandoned++
+		}
+	}
+	for async := pool.queue.tryPop(); async != nil; async = pool.queue.tryPop() {
+		reject(async)
+	}
+	if pool.overflow.Strategy == OverflowSpill {
+		pool.spillLock.Lock()
+		for async, ok := pool.overflow.Store.Pop(); ok; async, ok = pool.overflow.Store.Pop() {
+			reject(async)
+		}
+		pool.spillLock.Unlock()
+	}
+	return abandoned
+}
+
+func (pool *workerPool) currentSize() int {
+	return int(pool.workers.Load())
+}
+
+func (pool *workerPool) shutdown() {
+	pool.Lock()
+	defer pool.Unlock()
+	if !pool.stopped.enable() {
+		return
+	}
+	close(pool.done)
+	for ; pool.size > 0; pool.size-- {
+		pool.retire <- true
+		pool.workers.decrement()
+	}
+}
+
+func (pool *workerPool) spawn() {
+	pool.workers.increment()
+	go pool.worker()
+}
+
+func (pool *workerPool) worker() {
+	for {
+		async, ok := pool.queue.pop(pool.retire, pool.spilled)
+		if !ok {
+			return
+		}
+		if async == nil {
+			if async = pool.takeSpilled(); async == nil {
+				continue
+			}
+		} else if pool.overflow.Strategy == OverflowSpill {
+			pool.unspill()
+		}
+		pool.recordWait(time.Since(async.queuedAt))
+		pool.bus.handleAsync(async)
+		pool.pending.decrement()
+	}
+}
+
+func (pool *workerPool) recordWait(wait time.Duration) {
+	for {
+		current := pool.maxWait.Load()
+		if int64(wait) <= current || pool.maxWait.CompareAndSwap(current, int64(wait)) {
+			return
+		}
+	}
+}
+
+func (pool *workerPool) autoscaler(policy AutoscalePolicy) {
+	ticker := time.NewTicker(policy.Interval)
+	defer ticker.Stop()
+	lastPressure := time.Now()
+	for {
+		select {
+		case <-pool.done:
+			return
+		case now := <-ticker.C:
+			size := pool.currentSize()
+			depth := pool.queue.length()
+			wait := time.Duration(pool.maxWait.Swap(0))
+			if depth > 0 {
+				lastPressure = now
+			}
+			switch {
+			case size < policy.MinWorkers:
+				pool.resize(policy.MinWorkers)
+			case size > policy.MaxWorkers:
+				pool.resize(policy.MaxWorkers)
+			case policy.shouldGrow(depth, wait) && size < policy.MaxWorkers:
+				pool.resize(size + 1)
+			case policy.IdleTimeout > 0 && now.Sub(lastPressure) >= policy.IdleTimeout && size > policy.MinWorkers:
+				lastPressure = now
+				pool.resize(size - 1)
+			}
+		}
+	}
+}